@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"os"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 
@@ -13,6 +15,15 @@ func main() {
 	ebiten.SetWindowTitle("Ebiten Fullstack Template")
 
 	game := client.NewGame()
+	// INTERP_DELAY (e.g. "150ms") overrides how far behind other players
+	// are rendered; the web page sets it from the interp query parameter.
+	if v := os.Getenv("INTERP_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			game.SetInterpolationDelay(d)
+		} else {
+			log.Printf("invalid INTERP_DELAY %q, using %v", v, client.DefaultInterpolationDelay)
+		}
+	}
 	if err := ebiten.RunGame(game); err != nil {
 		log.Fatal(err)
 	}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,23 +15,49 @@ import (
 )
 
 func main() {
-	srv := server.New(":8080")
+	cfg, err := server.LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(server.NewLogger(cfg, os.Stderr))
+	if cfg.Dev {
+		slog.Warn("dev mode: accepting WebSocket connections from any origin")
+	}
+	srv := server.New(cfg)
 
 	// Run server in background; graceful shutdown on SIGINT/SIGTERM.
 	go func() {
 		if err := srv.Run(); err != nil {
-			log.Fatal(err)
+			slog.Error("server failed", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	// SIGHUP reloads the TLS certificate, e.g. after a renewal.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := srv.ReloadCertificates(); err != nil {
+				slog.Error("reload TLS certificate failed", "err", err)
+			} else {
+				slog.Info("reloaded TLS certificate")
+			}
 		}
 	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	slog.Info("shutting down server")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainPeriod+10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("shutdown error: %v", err)
+		slog.Error("shutdown failed", "err", err)
 	}
-	log.Println("server stopped")
+	slog.Info("server stopped")
 }
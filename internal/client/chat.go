@@ -0,0 +1,151 @@
+package client
+
+import (
+	"image/color"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+const (
+	// chatLogSize is how many chat lines are kept for scrolling back.
+	chatLogSize = 200
+
+	// chatVisibleLines is how many lines the chat box shows at once.
+	chatVisibleLines = 6
+
+	// chatLineChars wraps chat lines to fit the screen in the debug font.
+	chatLineChars = (ScreenWidth - 16) / 6
+
+	chatLineHeight = 16
+)
+
+// chat is the in-game chat: a scrolling log of lines and an input box opened
+// with Enter or T.
+type chat struct {
+	lines []string
+
+	// scroll is how many lines the view is scrolled back from the newest.
+	scroll int
+
+	input  textInput
+	typing bool
+}
+
+// newChat creates an empty chat.
+func newChat() chat {
+	return chat{input: textInput{max: 200}}
+}
+
+// add appends a chat message to the log, wrapping long lines.
+func (c *chat) add(m protocol.ChatData) {
+	line := m.Text
+	switch {
+	case m.From == "":
+		line = "* " + m.Text
+	case m.Name != "":
+		line = m.Name + ": " + m.Text
+	default:
+		line = m.From + ": " + m.Text
+	}
+	for _, l := range wrap(line, chatLineChars) {
+		c.lines = append(c.lines, l)
+		if c.scroll > 0 {
+			c.scroll++ // keep the view where the reader left it
+		}
+	}
+	if over := len(c.lines) - chatLogSize; over > 0 {
+		c.lines = c.lines[over:]
+	}
+	c.scroll = min(c.scroll, max(len(c.lines)-chatVisibleLines, 0))
+}
+
+// update handles chat keys. It reports whether the chat has the keyboard,
+// in which case the game should ignore key input.
+func (c *chat) update(n *Network) bool {
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyPageUp):
+		c.scroll = min(c.scroll+chatVisibleLines, max(len(c.lines)-chatVisibleLines, 0))
+	case inpututil.IsKeyJustPressed(ebiten.KeyPageDown):
+		c.scroll = max(c.scroll-chatVisibleLines, 0)
+	}
+
+	if !c.typing {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyT) {
+			// Start typing on the next frame so the T itself is not entered.
+			c.input.reset()
+			c.typing = true
+			return true
+		}
+		return false
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		c.typing = false
+		return true
+	}
+	if c.input.update() {
+		if text := strings.TrimSpace(c.input.String()); strings.HasPrefix(text, "/") {
+			n.SendCommand(text[1:])
+		} else if text != "" {
+			n.SendChat(text)
+		}
+		c.typing = false
+		c.scroll = 0
+	}
+	return true
+}
+
+// reset clears the chat; used after reconnecting, as the server sends the
+// room's recent history again.
+func (c *chat) reset() {
+	c.lines = nil
+	c.scroll = 0
+	c.typing = false
+}
+
+// draw renders the chat log and, while typing, the input box at the bottom
+// left of the screen.
+func (c *chat) draw(screen *ebiten.Image) {
+	bottom := ScreenHeight - 4
+	if c.typing {
+		bottom -= chatLineHeight + 4
+		vector.DrawFilledRect(screen, 0, float32(ScreenHeight-chatLineHeight-6), ScreenWidth, chatLineHeight+6,
+			color.RGBA{A: 200}, false)
+		c.input.draw(screen, "> ", 4, ScreenHeight-chatLineHeight-3)
+	}
+
+	end := len(c.lines) - c.scroll
+	start := max(end-chatVisibleLines, 0)
+	for i, line := range c.lines[start:end] {
+		y := bottom - (end-start-i)*chatLineHeight
+		ebitenutil.DebugPrintAt(screen, line, 4, y)
+	}
+	if c.scroll > 0 {
+		ebitenutil.DebugPrintAt(screen, "(PgDn for newer messages)", 4, bottom-(chatVisibleLines+1)*chatLineHeight)
+	}
+}
+
+// wrap splits s into lines of at most width characters, breaking at spaces
+// where possible.
+func wrap(s string, width int) []string {
+	var lines []string
+	r := []rune(s)
+	for len(r) > width {
+		cut := width
+		for i := width; i > width/2; i-- {
+			if r[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		lines = append(lines, string(r[:cut]))
+		r = []rune(strings.TrimLeft(string(r[cut:]), " "))
+	}
+	return append(lines, string(r))
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+const (
+	// clockBurst samples are taken clockSampleGap apart after connecting,
+	// then one more every clockResyncInterval to follow drift.
+	clockBurst          = 8
+	clockSampleGap      = 100 * time.Millisecond
+	clockResyncInterval = 15 * time.Second
+
+	// clockSamples is how many recent samples the estimate is based on.
+	clockSamples = 16
+)
+
+// clockSample is one time sync exchange: the estimated offset of the server
+// clock from ours and the round trip it took.
+type clockSample struct {
+	offset, rtt time.Duration
+}
+
+// serverClock estimates the server clock NTP-style from time sync samples.
+// It is safe for concurrent use.
+type serverClock struct {
+	mu      sync.Mutex
+	samples []clockSample // oldest first
+	offset  time.Duration
+	synced  bool
+}
+
+// add records the answer to a sample sent at sent and received at received,
+// where the server read its clock at server.
+func (c *serverClock) add(sent, received, server time.Time) {
+	rtt := received.Sub(sent)
+	if rtt < 0 {
+		return
+	}
+	// Assume the server answered halfway through the round trip.
+	offset := server.Sub(sent.Add(rtt / 2))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, clockSample{offset: offset, rtt: rtt})
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[len(c.samples)-clockSamples:]
+	}
+
+	// Samples with long round trips were probably delayed one way only, so
+	// use the median offset of the faster half.
+	sorted := slices.Clone(c.samples)
+	slices.SortFunc(sorted, func(a, b clockSample) int { return cmp.Compare(a.rtt, b.rtt) })
+	fast := sorted[:(len(sorted)+1)/2]
+	offsets := make([]time.Duration, len(fast))
+	for i, s := range fast {
+		offsets[i] = s.offset
+	}
+	slices.Sort(offsets)
+	c.offset = offsets[len(offsets)/2]
+	c.synced = true
+}
+
+// now returns the estimated server time and whether any sample was taken.
+func (c *serverClock) now() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset), c.synced
+}
+
+// syncClock sends time sync samples on conn until ctx is done. The answers
+// are fed to the clock by the read loop.
+func (n *Network) syncClock(ctx context.Context, conn *websocket.Conn) {
+	for i := 0; ; i++ {
+		err := n.write(ctx, conn, protocol.MsgTimeSync, protocol.TimeSyncData{
+			Client: time.Now().UnixMilli(),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("write time sync error: %v", err)
+			}
+			return
+		}
+		wait := clockResyncInterval
+		if i < clockBurst-1 {
+			wait = clockSampleGap
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// ServerTime returns the estimated server clock, for timers and countdowns
+// that must agree across players. ok is false until the first time sync
+// sample is answered, in which case the local clock is returned.
+func (n *Network) ServerTime() (t time.Time, ok bool) {
+	return n.clock.now()
+}
@@ -1,24 +1,24 @@
 package client
 
 import (
-	"encoding/json"
 	"fmt"
 	"image/color"
 	"log"
-	"math"
+	"strings"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 
 	"ebiten-fullstack-template/internal/protocol"
+	"ebiten-fullstack-template/internal/sim"
 )
 
 const (
-	ScreenWidth  = 640
-	ScreenHeight = 480
-	PlayerRadius = 8
-	PlayerSpeed  = 3
+	ScreenWidth  = sim.WorldWidth
+	ScreenHeight = sim.WorldHeight
+	PlayerRadius = sim.PlayerRadius
 )
 
 // Game implements the ebiten.Game interface.
@@ -26,24 +26,34 @@ type Game struct {
 	x, y    float64
 	network *Network
 
+	// prediction applies local inputs immediately and reconciles them with server state.
+	prediction predictor
+
 	// Other players received from the server, keyed by player ID.
 	players map[string]protocol.PlayerInfo
 
-	// positionSynced is set once the client adopts the server-assigned spawn position.
-	positionSynced bool
+	// remote buffers state snapshots to render other players smoothly.
+	// remoteServerTime is set while it runs on the server clock; see
+	// snapshotTime.
+	remote           interpolator
+	remoteServerTime bool
+
+	// lobby is the scene shown until the client enters a room.
+	lobby lobby
+
+	// chat is the in-game chat log and input box.
+	chat chat
 
-	// wasConnected tracks previous frame connection state to detect reconnect and reset positionSynced.
+	// notice is a transient message shown under the status line.
+	notice      string
+	noticeUntil time.Time
+
+	// wasConnected tracks previous frame connection state to detect reconnect and reset prediction.
 	wasConnected bool
-}
 
-// moveToward returns (nx, ny) one step of speed toward (tx, ty) from (cx, cy).
-func moveToward(cx, cy, tx, ty, speed float64) (float64, float64) {
-	dx, dy := tx-cx, ty-cy
-	dist := math.Sqrt(dx*dx + dy*dy)
-	if dist <= speed {
-		return tx, ty
-	}
-	return cx + dx/dist*speed, cy + dy/dist*speed
+	// closeReason is why the server closed the last connection, kept to
+	// show once the client is connected again.
+	closeReason string
 }
 
 // NewGame creates a new Game with the player centered on the screen.
@@ -55,77 +65,137 @@ func NewGame() *Game {
 		y:       float64(ScreenHeight) / 2,
 		network: connectNetwork(),
 		players: make(map[string]protocol.PlayerInfo),
+		remote:  interpolator{delay: DefaultInterpolationDelay},
+		lobby:   newLobby(),
+		chat:    newChat(),
 	}
 }
 
-// Update handles input, sends position updates, and processes server messages.
-func (g *Game) Update() error {
-	prevX, prevY := g.x, g.y
+// inLobby reports whether the lobby scene is showing.
+func (g *Game) inLobby() bool {
+	return g.network != nil && g.network.IsConnected() && g.network.Room() == ""
+}
 
-	if ebiten.IsKeyPressed(ebiten.KeyArrowUp) {
-		g.y -= PlayerSpeed
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyArrowDown) {
-		g.y += PlayerSpeed
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyArrowLeft) {
-		g.x -= PlayerSpeed
-	}
-	if ebiten.IsKeyPressed(ebiten.KeyArrowRight) {
-		g.x += PlayerSpeed
+// SetInterpolationDelay sets how far in the past other players are rendered.
+// Larger delays hide more network jitter at the cost of added latency.
+func (g *Game) SetInterpolationDelay(d time.Duration) {
+	g.remote.delay = d
+}
+
+// readInput samples the keyboard, mouse and touch state for this frame.
+func readInput() protocol.InputData {
+	in := protocol.InputData{
+		Up:    ebiten.IsKeyPressed(ebiten.KeyArrowUp),
+		Down:  ebiten.IsKeyPressed(ebiten.KeyArrowDown),
+		Left:  ebiten.IsKeyPressed(ebiten.KeyArrowLeft),
+		Right: ebiten.IsKeyPressed(ebiten.KeyArrowRight),
 	}
 
 	// Mouse: move toward cursor while left button is held.
 	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
 		cx, cy := ebiten.CursorPosition()
-		g.x, g.y = moveToward(g.x, g.y, float64(cx), float64(cy), PlayerSpeed)
-	}
-	// Touch: move toward first touch position.
-	for _, id := range ebiten.TouchIDs() {
-		tx, ty := ebiten.TouchPosition(id)
-		g.x, g.y = moveToward(g.x, g.y, float64(tx), float64(ty), PlayerSpeed)
-		break
+		in.Pointer, in.TX, in.TY = true, float64(cx), float64(cy)
+	} else {
+		// Touch: move toward first touch position.
+		for _, id := range ebiten.TouchIDs() {
+			tx, ty := ebiten.TouchPosition(id)
+			in.Pointer, in.TX, in.TY = true, float64(tx), float64(ty)
+			break
+		}
 	}
+	return in
+}
 
-	// Clamp to screen bounds.
-	if g.x < PlayerRadius {
-		g.x = PlayerRadius
-	}
-	if g.x > ScreenWidth-PlayerRadius {
-		g.x = ScreenWidth - PlayerRadius
+// Update handles input, sends it to the server, and processes server messages.
+func (g *Game) Update() error {
+	// Network: a new connection starts a new input sequence.
+	if g.network != nil {
+		connected := g.network.IsConnected()
+		if !connected && g.wasConnected {
+			g.closeReason = g.network.CloseReason()
+		}
+		if connected && !g.wasConnected {
+			g.prediction.reset()
+			g.remote.reset()
+			g.lobby.reset()
+			g.chat.reset()
+			// The status line shows the reason only while disconnected;
+			// keep it up a little longer.
+			if g.closeReason != "" {
+				if g.network.Room() == "" {
+					g.lobby.status = "Disconnected: " + g.closeReason
+				} else {
+					g.showNotice("Disconnected: " + g.closeReason)
+				}
+				g.closeReason = ""
+			}
+		}
+		g.wasConnected = connected
 	}
-	if g.y < PlayerRadius {
-		g.y = PlayerRadius
+
+	if g.inLobby() {
+		g.lobby.update(g.network)
+		g.processMessages()
+		return nil
 	}
-	if g.y > ScreenHeight-PlayerRadius {
-		g.y = ScreenHeight - PlayerRadius
+
+	// The chat box takes the keyboard while the player is typing.
+	in := protocol.InputData{}
+	if g.network == nil || !g.network.IsConnected() || !g.chat.update(g.network) {
+		in = readInput()
 	}
+	if !in.IsIdle() {
+		g.x, g.y = sim.Step(g.x, g.y, in)
 
-	// Network: send position update when the player moved.
-	if g.network != nil && g.network.IsConnected() && (g.x != prevX || g.y != prevY) {
-		g.network.SendPosition(g.x, g.y)
+		// Network: the server replays the same input authoritatively.
+		if g.network != nil && g.network.IsConnected() {
+			g.network.SendInput(g.prediction.predict(in))
+		}
 	}
 
 	// Network: process incoming server messages.
 	if g.network != nil {
-		connected := g.network.IsConnected()
-		if connected && !g.wasConnected {
-			g.positionSynced = false
-		}
-		g.wasConnected = connected
 		g.processMessages()
 	}
 
 	return nil
 }
 
+// snapshotTime returns when a state snapshot was taken. Once the server
+// clock is known that is the server's timestamp, which spaces snapshots
+// evenly however jittery the network; until then it is the arrival time.
+// Switching clocks empties the interpolation buffer.
+func (g *Game) snapshotTime(state protocol.StateData) time.Time {
+	at, serverTime := time.Now(), false
+	if _, ok := g.network.ServerTime(); ok && state.Time != 0 {
+		at, serverTime = time.UnixMilli(state.Time), true
+	}
+	if serverTime != g.remoteServerTime {
+		g.remote.reset()
+		g.remoteServerTime = serverTime
+	}
+	return at
+}
+
+// remoteNow returns the current time on the interpolation buffer's clock.
+// On the server clock, snapshots arrive about half a round trip after they
+// were taken, so that is subtracted as well.
+func (g *Game) remoteNow() time.Time {
+	if g.remoteServerTime {
+		if t, ok := g.network.ServerTime(); ok {
+			return t.Add(-g.network.RTT() / 2)
+		}
+	}
+	return time.Now()
+}
+
 // processMessages drains the network message queue and updates local state.
 func (g *Game) processMessages() {
 	for _, env := range g.network.ReceiveMessages() {
 		switch env.Type {
 		case protocol.MsgState:
 			var state protocol.StateData
-			if err := json.Unmarshal(env.Data, &state); err != nil {
+			if err := env.Decode(&state); err != nil {
 				log.Printf("unmarshal state error: %v", err)
 				continue
 			}
@@ -134,78 +204,170 @@ func (g *Game) processMessages() {
 				newPlayers[p.ID] = p
 			}
 			g.players = newPlayers
+			g.remote.push(g.snapshotTime(state), state.Players)
 
-			// Sync local position from server on first state (randomized spawn).
-			if !g.positionSynced && g.network != nil {
-				if myID := g.network.PlayerID(); myID != "" {
-					if me, ok := newPlayers[myID]; ok {
-						g.x = me.X
-						g.y = me.Y
-						g.positionSynced = true
-					}
-				}
+			// Reconcile: rebase unacknowledged inputs on our authoritative position.
+			if me, ok := newPlayers[g.network.PlayerID()]; ok {
+				g.x, g.y = g.prediction.reconcile(me.X, me.Y, me.LastSeq)
 			}
 
 		case protocol.MsgJoin:
 			var join protocol.JoinData
-			if err := json.Unmarshal(env.Data, &join); err != nil {
+			if err := env.Decode(&join); err != nil {
 				log.Printf("unmarshal join error: %v", err)
 				continue
 			}
 			g.players[join.ID] = protocol.PlayerInfo{
-				ID: join.ID, X: join.X, Y: join.Y, Color: join.Color,
+				ID: join.ID, Name: join.Name, X: join.X, Y: join.Y, Color: join.Color,
 			}
 
 		case protocol.MsgLeave:
 			var leave protocol.LeaveData
-			if err := json.Unmarshal(env.Data, &leave); err != nil {
+			if err := env.Decode(&leave); err != nil {
 				log.Printf("unmarshal leave error: %v", err)
 				continue
 			}
 			delete(g.players, leave.ID)
+
+		case protocol.MsgRoomList, protocol.MsgJoinResult:
+			if err := g.lobby.handle(env); err != nil {
+				log.Printf("unmarshal %s error: %v", env.Type, err)
+			}
+
+		case protocol.MsgChat:
+			var msg protocol.ChatData
+			if err := env.Decode(&msg); err != nil {
+				log.Printf("unmarshal chat error: %v", err)
+				continue
+			}
+			g.chat.add(msg)
+
+		case protocol.MsgChatHistory:
+			var history protocol.ChatHistoryData
+			if err := env.Decode(&history); err != nil {
+				log.Printf("unmarshal chat history error: %v", err)
+				continue
+			}
+			for _, msg := range history.Messages {
+				g.chat.add(msg)
+			}
+
+		case protocol.MsgCommandResult:
+			var res protocol.CommandResultData
+			if err := env.Decode(&res); err != nil {
+				log.Printf("unmarshal command result error: %v", err)
+				continue
+			}
+			for _, line := range strings.Split(res.Text, "\n") {
+				g.chat.add(protocol.ChatData{Text: line})
+			}
+
+		case protocol.MsgNameResult:
+			if g.network.Room() == "" {
+				if err := g.lobby.handle(env); err != nil {
+					log.Printf("unmarshal name result error: %v", err)
+				}
+				continue
+			}
+			var res protocol.NameResultData
+			if err := env.Decode(&res); err != nil {
+				log.Printf("unmarshal name result error: %v", err)
+				continue
+			}
+			if !res.OK {
+				g.showNotice("Name rejected: " + res.Error)
+			}
 		}
 	}
 }
 
+// showNotice displays msg under the status line for a few seconds.
+func (g *Game) showNotice(msg string) {
+	g.notice = msg
+	g.noticeUntil = time.Now().Add(5 * time.Second)
+}
+
+// drawNameplate prints a player's name (or ID) centred above its dot.
+func drawNameplate(screen *ebiten.Image, p protocol.PlayerInfo, x, y float64) {
+	label := p.Name
+	if label == "" {
+		label = p.ID
+	}
+	// The debug font is 6 px wide and 16 px tall.
+	ebitenutil.DebugPrintAt(screen, label, int(x)-len([]rune(label))*3, int(y)-PlayerRadius-18)
+}
+
 // Draw renders the player dot, other players, and status text.
 func (g *Game) Draw(screen *ebiten.Image) {
 	screen.Fill(color.RGBA{R: 34, G: 34, B: 34, A: 255})
 
+	if g.inLobby() {
+		g.lobby.draw(screen, g.network.Name())
+		return
+	}
+
 	// Draw other players.
 	myID := ""
 	if g.network != nil {
 		myID = g.network.PlayerID()
 	}
+	renderAt := g.remote.renderTime(g.remoteNow())
 	for _, p := range g.players {
 		if p.ID == myID {
 			continue // we draw ourselves below
 		}
-		vector.DrawFilledCircle(screen, float32(p.X), float32(p.Y), PlayerRadius,
+		x, y, ok := g.remote.position(p.ID, renderAt)
+		if !ok {
+			x, y = p.X, p.Y
+		}
+		vector.DrawFilledCircle(screen, float32(x), float32(y), PlayerRadius,
 			color.RGBA{R: p.Color.R, G: p.Color.G, B: p.Color.B, A: 255}, true)
+		drawNameplate(screen, p, x, y)
 	}
 
 	// Draw the local player.
 	playerColor := color.RGBA{R: 0, G: 200, B: 80, A: 255}
 	if g.network != nil && g.network.PlayerID() != "" {
 		c := g.network.PlayerColor()
+		// The server may recolor us after the welcome, e.g. via /color.
+		if me, ok := g.players[myID]; ok {
+			c = me.Color
+		}
 		playerColor = color.RGBA{R: c.R, G: c.G, B: c.B, A: 255}
 	}
 	vector.DrawFilledCircle(screen, float32(g.x), float32(g.y), PlayerRadius, playerColor, true)
 	// Draw a white outline ring so the local player is easy to identify.
 	vector.StrokeCircle(screen, float32(g.x), float32(g.y), PlayerRadius+3, 1.5,
 		color.RGBA{R: 255, G: 255, B: 255, A: 180}, true)
+	if me, ok := g.players[myID]; ok {
+		drawNameplate(screen, me, g.x, g.y)
+	}
+
+	g.chat.draw(screen)
 
 	// Status text.
 	status := "Arrow keys / click / touch to move"
 	if g.network != nil {
-		if g.network.IsConnected() {
+		if fatal := g.network.Fatal(); fatal != "" {
+			status = fatal
+		} else if g.network.IsConnected() {
 			count := len(g.players)
-			status = fmt.Sprintf("%s | %d player(s) | Arrow keys / click / touch to move", g.network.PlayerID(), count)
+			ping := "-"
+			if rtt := g.network.RTT(); rtt > 0 {
+				ping = fmt.Sprintf("%d ms", rtt.Milliseconds())
+			}
+			status = fmt.Sprintf("%s | room %s | %d player(s) | ping %s | Arrows / click / touch to move | Enter: chat",
+				g.network.PlayerID(), g.network.Room(), count, ping)
+		} else if reason := g.network.CloseReason(); reason != "" {
+			status = "Disconnected: " + reason + ". Reconnecting..."
 		} else {
 			status = "Connecting..."
 		}
 	}
 	ebitenutil.DebugPrint(screen, status)
+	if g.notice != "" && time.Now().Before(g.noticeUntil) {
+		ebitenutil.DebugPrintAt(screen, g.notice, 0, 16)
+	}
 }
 
 // Layout returns the logical screen size.
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+	"ebiten-fullstack-template/internal/sim"
+)
+
+const (
+	// DefaultInterpolationDelay is how far behind the newest snapshot remote
+	// players are rendered. It should cover a couple of server ticks.
+	DefaultInterpolationDelay = 100 * time.Millisecond
+
+	// maxExtrapolation caps how far past the newest snapshot a remote player
+	// is projected when snapshots arrive late.
+	maxExtrapolation = 200 * time.Millisecond
+
+	// maxSnapshots bounds the interpolation buffer.
+	maxSnapshots = 256
+)
+
+// point is a player position inside a snapshot.
+type point struct {
+	x, y float64
+}
+
+// snapshot is the set of player positions received at one instant.
+type snapshot struct {
+	at        time.Time
+	positions map[string]point
+}
+
+// interpolator buffers timestamped snapshots and renders remote players a
+// fixed delay in the past, so they move smoothly between snapshots instead of
+// jumping whenever one arrives.
+type interpolator struct {
+	delay     time.Duration
+	snapshots []snapshot // oldest first
+}
+
+// push records the player positions received at time at.
+func (ip *interpolator) push(at time.Time, players []protocol.PlayerInfo) {
+	snap := snapshot{at: at, positions: make(map[string]point, len(players))}
+	for _, p := range players {
+		snap.positions[p.ID] = point{p.X, p.Y}
+	}
+	ip.snapshots = append(ip.snapshots, snap)
+
+	// Drop snapshots that can no longer bracket the render time.
+	horizon := at.Add(-ip.delay - maxExtrapolation)
+	n := 0
+	for len(ip.snapshots)-n > 2 && ip.snapshots[n+1].at.Before(horizon) {
+		n++
+	}
+	if len(ip.snapshots)-n > maxSnapshots {
+		n = len(ip.snapshots) - maxSnapshots
+	}
+	ip.snapshots = ip.snapshots[n:]
+}
+
+// renderTime returns the instant remote players are rendered at.
+func (ip *interpolator) renderTime(now time.Time) time.Time {
+	return now.Add(-ip.delay)
+}
+
+// position returns the position of player id at time t. It interpolates
+// between the snapshots around t and extrapolates, up to maxExtrapolation,
+// past the newest one. ok is false if no snapshot contains the player.
+func (ip *interpolator) position(id string, t time.Time) (x, y float64, ok bool) {
+	// Find the newest snapshot at or before t (from) and the next one (to)
+	// that contain the player.
+	from, to := -1, -1
+	for i, snap := range ip.snapshots {
+		if _, has := snap.positions[id]; !has {
+			continue
+		}
+		if !snap.at.After(t) {
+			from = i
+			continue
+		}
+		to = i
+		break
+	}
+
+	switch {
+	case from < 0 && to < 0:
+		return 0, 0, false
+
+	case from < 0:
+		// t predates the player's first snapshot.
+		p := ip.snapshots[to].positions[id]
+		return p.x, p.y, true
+
+	case to >= 0:
+		a, b := ip.snapshots[from], ip.snapshots[to]
+		pa, pb := a.positions[id], b.positions[id]
+		alpha := float64(t.Sub(a.at)) / float64(b.at.Sub(a.at))
+		return pa.x + (pb.x-pa.x)*alpha, pa.y + (pb.y-pa.y)*alpha, true
+	}
+
+	// t is past the newest snapshot: extrapolate from the last velocity.
+	last := ip.snapshots[from]
+	pl := last.positions[id]
+	prev := -1
+	for i := from - 1; i >= 0; i-- {
+		if _, has := ip.snapshots[i].positions[id]; has {
+			prev = i
+			break
+		}
+	}
+	if prev < 0 {
+		return pl.x, pl.y, true
+	}
+	before := ip.snapshots[prev]
+	pp := before.positions[id]
+	span := last.at.Sub(before.at)
+	if span <= 0 {
+		return pl.x, pl.y, true
+	}
+	ahead := min(t.Sub(last.at), maxExtrapolation)
+	f := float64(ahead) / float64(span)
+	x, y = sim.Clamp(pl.x+(pl.x-pp.x)*f, pl.y+(pl.y-pp.y)*f)
+	return x, y, true
+}
+
+// reset discards all buffered snapshots.
+func (ip *interpolator) reset() {
+	ip.snapshots = nil
+}
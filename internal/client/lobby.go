@@ -0,0 +1,254 @@
+package client
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+	"unicode"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+const (
+	// lobbyRefreshInterval is how often the room list is requested again.
+	lobbyRefreshInterval = 2 * time.Second
+
+	// Lobby layout: one room per text line, starting at lobbyListTop.
+	lobbyListTop    = 48
+	lobbyLineHeight = 16
+	lobbyMaxRows    = 20
+)
+
+// lobby is the scene shown before entering a room. It lists the public rooms
+// with their player counts, creates public or private rooms and joins rooms
+// by join code.
+type lobby struct {
+	rooms    []protocol.RoomInfo
+	selected int
+
+	// code is the join code being typed while enteringCode is set.
+	code         textInput
+	enteringCode bool
+
+	// name is the display name being typed while enteringName is set.
+	name         textInput
+	enteringName bool
+
+	// waiting is set while a create or join request is in flight.
+	waiting bool
+	status  string
+
+	lastRefresh time.Time
+}
+
+// newLobby creates the lobby scene.
+func newLobby() lobby {
+	return lobby{
+		code: textInput{
+			max: 32,
+			// Keep the case: rooms opened with ?room= may use lowercase,
+			// and the server matches generated codes in any case.
+			filter: func(r rune) (rune, bool) {
+				if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+					return 0, false
+				}
+				return r, true
+			},
+		},
+		name: textInput{
+			max: 16,
+			// The server only accepts ASCII names.
+			filter: func(r rune) (rune, bool) {
+				if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || r == '_' || r == '-' || r == '.') {
+					return 0, false
+				}
+				return r, true
+			},
+		},
+	}
+}
+
+// update handles lobby input and keeps the room list fresh.
+func (l *lobby) update(n *Network) {
+	if time.Since(l.lastRefresh) >= lobbyRefreshInterval {
+		n.ListRooms()
+		l.lastRefresh = time.Now()
+	}
+	if l.waiting {
+		return
+	}
+
+	if l.enteringName {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			l.enteringName = false
+			return
+		}
+		if l.name.update() {
+			l.enteringName = false
+			n.SetName(l.name.String())
+		}
+		return
+	}
+
+	if l.enteringCode {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+			l.enteringCode = false
+			return
+		}
+		if l.code.update() && len(l.code.text) > 0 {
+			l.enteringCode = false
+			l.join(n, l.code.String())
+		}
+		return
+	}
+
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyArrowUp):
+		l.selected = max(l.selected-1, 0)
+	case inpututil.IsKeyJustPressed(ebiten.KeyArrowDown):
+		l.selected = min(l.selected+1, max(len(l.rooms)-1, 0))
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+		if l.selected < len(l.rooms) {
+			l.join(n, l.rooms[l.selected].Code)
+		}
+	case inpututil.IsKeyJustPressed(ebiten.KeyC):
+		l.create(n, false)
+	case inpututil.IsKeyJustPressed(ebiten.KeyP):
+		l.create(n, true)
+	case inpututil.IsKeyJustPressed(ebiten.KeyJ):
+		// Start typing on the next frame so the J itself is not entered.
+		l.code.reset()
+		l.enteringCode = true
+		l.status = ""
+	case inpututil.IsKeyJustPressed(ebiten.KeyN):
+		l.name.reset()
+		l.name.text = append(l.name.text, []rune(n.Name())...)
+		l.enteringName = true
+		l.status = ""
+	case inpututil.IsKeyJustPressed(ebiten.KeyR):
+		l.lastRefresh = time.Time{}
+	}
+
+	// Mouse / touch: click or tap a room to join it.
+	if _, y, ok := justPressedPointer(); ok {
+		row := (y - lobbyListTop) / lobbyLineHeight
+		if y >= lobbyListTop && row < len(l.rooms) && row < lobbyMaxRows {
+			l.selected = row
+			l.join(n, l.rooms[row].Code)
+		}
+	}
+}
+
+func (l *lobby) join(n *Network, code string) {
+	l.waiting = true
+	l.status = "Joining " + code + "..."
+	n.JoinRoom(code)
+}
+
+func (l *lobby) create(n *Network, private bool) {
+	l.waiting = true
+	l.status = "Creating room..."
+	n.CreateRoom(private)
+}
+
+// handle processes lobby replies from the server.
+func (l *lobby) handle(env protocol.Envelope) error {
+	switch env.Type {
+	case protocol.MsgRoomList:
+		var list protocol.RoomListData
+		if err := env.Decode(&list); err != nil {
+			return err
+		}
+		l.rooms = list.Rooms
+		l.selected = min(l.selected, max(len(l.rooms)-1, 0))
+
+	case protocol.MsgJoinResult:
+		var res protocol.JoinResultData
+		if err := env.Decode(&res); err != nil {
+			return err
+		}
+		l.waiting = false
+		if !res.OK {
+			l.status = res.Error
+		}
+
+	case protocol.MsgNameResult:
+		var res protocol.NameResultData
+		if err := env.Decode(&res); err != nil {
+			return err
+		}
+		if res.OK {
+			l.status = "Your name is " + res.Name
+		} else {
+			l.status = res.Error
+		}
+	}
+	return nil
+}
+
+// reset forgets in-flight requests; used after reconnecting.
+func (l *lobby) reset() {
+	l.waiting = false
+	l.enteringCode = false
+	l.enteringName = false
+	l.status = ""
+	l.lastRefresh = time.Time{}
+}
+
+// draw renders the room browser.
+func (l *lobby) draw(screen *ebiten.Image, name string) {
+	title := "LOBBY - public rooms"
+	if name != "" {
+		title += " - playing as " + name
+	}
+	ebitenutil.DebugPrintAt(screen, title, 16, 16)
+
+	if len(l.rooms) == 0 {
+		ebitenutil.DebugPrintAt(screen, "No public rooms yet. Press C to create one.", 32, lobbyListTop)
+	}
+	for i, r := range l.rooms {
+		if i == lobbyMaxRows {
+			break
+		}
+		y := lobbyListTop + i*lobbyLineHeight
+		if i == l.selected {
+			vector.DrawFilledRect(screen, 16, float32(y), ScreenWidth-32, lobbyLineHeight,
+				color.RGBA{R: 60, G: 60, B: 90, A: 255}, false)
+		}
+		players := "players"
+		if r.Players == 1 {
+			players = "player"
+		}
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%-32s %3d %s", r.Code, r.Players, players), 32, y)
+	}
+
+	bottom := ScreenHeight - 3*lobbyLineHeight
+	if l.enteringName {
+		l.name.draw(screen, "Your name: ", 16, bottom-lobbyLineHeight)
+	} else if l.enteringCode {
+		l.code.draw(screen, "Join code: ", 16, bottom-lobbyLineHeight)
+	} else if l.status != "" {
+		ebitenutil.DebugPrintAt(screen, l.status, 16, bottom-lobbyLineHeight)
+	}
+	ebitenutil.DebugPrintAt(screen, "Up/Down + Enter or click: join   N: set name   R: refresh", 16, bottom)
+	ebitenutil.DebugPrintAt(screen, "C: create room   P: create private room   J: enter join code", 16, bottom+lobbyLineHeight)
+}
+
+// justPressedPointer returns the position of a mouse click or touch that
+// started this frame.
+func justPressedPointer() (x, y int, ok bool) {
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		x, y = ebiten.CursorPosition()
+		return x, y, true
+	}
+	for _, id := range inpututil.AppendJustPressedTouchIDs(nil) {
+		x, y = ebiten.TouchPosition(id)
+		return x, y, true
+	}
+	return 0, 0, false
+}
@@ -2,8 +2,9 @@ package client
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
+	"net/url"
 	"os"
 	"sync"
 	"time"
@@ -17,11 +18,15 @@ const (
 	reconnectInitial = 1 * time.Second
 	reconnectMax     = 30 * time.Second
 	defaultWSURL     = "ws://localhost:8080/ws"
+
+	// snapshotHistory is how many decoded snapshots are kept as delta bases.
+	snapshotHistory = 64
 )
 
 // Network manages the WebSocket connection to the game server.
 type Network struct {
 	serverURL string
+	codec     protocol.Codec
 	messages  chan protocol.Envelope
 
 	mu            sync.Mutex
@@ -29,25 +34,107 @@ type Network struct {
 	connected     bool
 	playerID      string
 	playerColor   protocol.Color
+	room          string
 	cancel        context.CancelFunc
 	stopReconnect bool
+
+	// token resumes our player after a reconnect.
+	token string
+
+	// name is the display name we asked for; it is sent again on every
+	// connection.
+	name string
+
+	// rtt is the round-trip time the server last measured.
+	rtt time.Duration
+
+	// clock estimates the server clock; samples survive reconnects.
+	clock serverClock
+
+	// fatal is set when the server rejected this client for good, e.g. an
+	// incompatible protocol version; reconnecting will not help.
+	fatal string
+
+	// closeReason is why the server closed the last connection, shown
+	// while reconnecting; "" if it gave none.
+	closeReason string
+
+	// snapshots holds recently decoded state snapshots by tick, used to
+	// rebuild full state from deltas. Owned by the read loop.
+	snapshots map[uint32]map[string]protocol.PlayerInfo
 }
 
 // connectNetwork creates a Network and starts connecting to the server.
-// It is called automatically by NewGame.
+// It is called automatically by NewGame. The wire codec defaults to binary;
+// set WS_CODEC=json to see readable frames in the browser dev tools.
 func connectNetwork() *Network {
-	url := os.Getenv("WS_URL")
-	if url == "" {
-		url = defaultWSURL
+	serverURL := os.Getenv("WS_URL")
+	if serverURL == "" {
+		serverURL = defaultWSURL
+	}
+	codec := protocol.BinaryCodec
+	if name := os.Getenv("WS_CODEC"); name != "" {
+		if c, ok := protocol.CodecByName(name); ok {
+			codec = c
+		} else {
+			log.Printf("unknown codec %q, using %s", name, codec.Name())
+		}
 	}
 	n := &Network{
-		serverURL: url,
+		serverURL: serverURL,
+		codec:     codec,
 		messages:  make(chan protocol.Envelope, 256),
 	}
+	// The page may name a room to enter. Keep it with the room entered
+	// later so that being kicked out of it forgets it too.
+	if u, err := url.Parse(serverURL); err == nil {
+		q := u.Query()
+		n.room = q.Get("room")
+		q.Del("room")
+		u.RawQuery = q.Encode()
+		n.serverURL = u.String()
+	}
 	go n.connectLoop()
 	return n
 }
 
+// dialURL returns the server URL with the negotiated codec appended. Once a
+// room was entered, reconnects go straight back into it.
+func (n *Network) dialURL() string {
+	u, err := url.Parse(n.serverURL)
+	if err != nil {
+		return n.serverURL
+	}
+	q := u.Query()
+	q.Set("codec", n.codec.Name())
+	n.mu.Lock()
+	if n.room != "" {
+		q.Set("room", n.room)
+	}
+	n.mu.Unlock()
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// frameType returns the WebSocket message type used by the codec.
+func (n *Network) frameType() websocket.MessageType {
+	if n.codec.Binary() {
+		return websocket.MessageBinary
+	}
+	return websocket.MessageText
+}
+
+// write encodes and sends one message on conn.
+func (n *Network) write(ctx context.Context, conn *websocket.Conn, msgType protocol.MessageType, data interface{}) error {
+	msg, err := n.codec.Marshal(msgType, data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return conn.Write(ctx, n.frameType(), msg)
+}
+
 func (n *Network) connectLoop() {
 	delay := reconnectInitial
 	for {
@@ -64,7 +151,9 @@ func (n *Network) connectLoop() {
 		n.mu.Unlock()
 
 		log.Printf("connecting to %s", n.serverURL)
-		conn, _, err := websocket.Dial(ctx, n.serverURL, nil)
+		conn, _, err := websocket.Dial(ctx, n.dialURL(), &websocket.DialOptions{
+			Subprotocols: []string{protocol.Subprotocol(n.codec)},
+		})
 		if err != nil {
 			log.Printf("dial error: %v", err)
 			cancel()
@@ -78,23 +167,60 @@ func (n *Network) connectLoop() {
 			continue
 		}
 
+		// Say hello before anything else can be sent; the server answers
+		// before the welcome.
+		n.mu.Lock()
+		hello := protocol.HelloData{
+			Version:  protocol.Version,
+			Build:    protocol.BuildHash,
+			Features: protocol.Features,
+			Token:    n.token,
+		}
+		n.mu.Unlock()
+		if err := n.write(ctx, conn, protocol.MsgHello, hello); err != nil {
+			log.Printf("write hello error: %v", err)
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+			cancel()
+			time.Sleep(delay)
+			continue
+		}
+		if name := n.Name(); name != "" {
+			if err := n.write(ctx, conn, protocol.MsgSetName, protocol.SetNameData{Name: name}); err != nil {
+				log.Printf("write set name error: %v", err)
+			}
+		}
+
 		n.mu.Lock()
 		n.conn = conn
 		n.connected = true
 		n.playerID = ""
 		n.playerColor = protocol.Color{}
+		n.rtt = 0
+		n.closeReason = ""
 		n.mu.Unlock()
-		delay = reconnectInitial
+		n.snapshots = make(map[uint32]map[string]protocol.PlayerInfo)
 		log.Println("websocket connected")
+		go n.syncClock(ctx, conn)
 
 		n.readLoop(ctx, conn)
 
 		n.mu.Lock()
 		n.connected = false
 		n.conn = nil
+		dropped := n.closeReason != ""
 		n.mu.Unlock()
 		_ = conn.Close(websocket.StatusNormalClosure, "")
 		cancel()
+
+		if !dropped {
+			delay = reconnectInitial
+			continue
+		}
+		// The server dropped us on purpose, e.g. for flooding or for being
+		// too slow; coming straight back would only repeat that, so back
+		// off until a connection ends normally.
+		time.Sleep(delay)
+		delay = min(delay*2, reconnectMax)
 	}
 }
 
@@ -102,34 +228,115 @@ func (n *Network) readLoop(ctx context.Context, conn *websocket.Conn) {
 	for {
 		msgType, data, err := conn.Read(ctx)
 		if err != nil {
+			var ce websocket.CloseError
+			if errors.As(err, &ce) && ce.Code == websocket.StatusCode(protocol.CloseIncompatible) {
+				log.Printf("rejected by server: %s", ce.Reason)
+				n.mu.Lock()
+				n.fatal = "Client is out of date: please reload the page"
+				n.stopReconnect = true
+				n.mu.Unlock()
+				return
+			}
+			if errors.As(err, &ce) && ce.Code == websocket.StatusCode(protocol.CloseKicked) {
+				// The room would turn us away; go back to the lobby.
+				log.Printf("kicked: %s", ce.Reason)
+				n.mu.Lock()
+				n.room = ""
+				n.closeReason = ce.Reason
+				n.mu.Unlock()
+				return
+			}
+			if errors.As(err, &ce) && ce.Reason != "" {
+				// E.g. flooding or too slow to keep up
+				// (protocol.CloseSlowConsumer); reconnecting after a
+				// pause is fine.
+				log.Printf("closed by server: %s (%d)", ce.Reason, ce.Code)
+				n.mu.Lock()
+				n.closeReason = ce.Reason
+				n.mu.Unlock()
+				return
+			}
 			if websocket.CloseStatus(err) != websocket.StatusNormalClosure &&
 				websocket.CloseStatus(err) != websocket.StatusGoingAway {
 				log.Printf("read error: %v", err)
 			}
 			return
 		}
-		if msgType != websocket.MessageText {
+		if msgType != n.frameType() {
 			continue
 		}
 
-		var env protocol.Envelope
-		if err := json.Unmarshal(data, &env); err != nil {
+		env, err := n.codec.Unmarshal(data)
+		if err != nil {
 			log.Printf("unmarshal error: %v", err)
 			continue
 		}
 
+		if env.Type == protocol.MsgHello {
+			var h protocol.HelloData
+			if err := env.Decode(&h); err == nil && h.Build != protocol.BuildHash {
+				log.Printf("server runs build %s, client runs %s", h.Build, protocol.BuildHash)
+			}
+			continue
+		}
+
+		if env.Type == protocol.MsgPing {
+			var ping protocol.PingData
+			if err := env.Decode(&ping); err != nil {
+				log.Printf("unmarshal ping error: %v", err)
+				continue
+			}
+			// Answer at once: the server measures the RTT from it and
+			// disconnects clients that stay silent.
+			if err := n.write(ctx, conn, protocol.MsgPong, protocol.PongData{Seq: ping.Seq}); err != nil {
+				log.Printf("write pong error: %v", err)
+			}
+			n.mu.Lock()
+			n.rtt = time.Duration(ping.RTT) * time.Millisecond
+			n.mu.Unlock()
+			continue
+		}
+
+		if env.Type == protocol.MsgTimeSync {
+			var ts protocol.TimeSyncData
+			if err := env.Decode(&ts); err != nil {
+				log.Printf("unmarshal time sync error: %v", err)
+				continue
+			}
+			n.clock.add(time.UnixMilli(ts.Client), time.Now(), time.UnixMilli(ts.Server))
+			continue
+		}
+
+		if env.Type == protocol.MsgJoinResult {
+			var res protocol.JoinResultData
+			if err := env.Decode(&res); err == nil && res.OK {
+				n.mu.Lock()
+				n.room = res.Code
+				n.mu.Unlock()
+				log.Printf("entered room %s", res.Code)
+			}
+		}
+
 		if env.Type == protocol.MsgWelcome {
 			var w protocol.WelcomeData
-			if err := json.Unmarshal(env.Data, &w); err == nil {
+			if err := env.Decode(&w); err == nil {
 				n.mu.Lock()
 				n.playerID = w.ID
 				n.playerColor = w.Color
+				n.token = w.Token
 				n.mu.Unlock()
 				log.Printf("welcome: id=%s color=(%d,%d,%d)",
 					w.ID, w.Color.R, w.Color.G, w.Color.B)
 			}
 		}
 
+		if env.Type == protocol.MsgState {
+			var ok bool
+			if env, ok = n.rebuildState(ctx, conn, env); !ok {
+				continue
+			}
+		}
+
 		select {
 		case n.messages <- env:
 		default:
@@ -138,6 +345,51 @@ func (n *Network) readLoop(ctx context.Context, conn *websocket.Conn) {
 	}
 }
 
+// rebuildState turns a keyframe or delta MsgState into an envelope carrying
+// the full state, and acknowledges it so the server can diff against it.
+// It reports false if the snapshot cannot be decoded.
+func (n *Network) rebuildState(ctx context.Context, conn *websocket.Conn, env protocol.Envelope) (protocol.Envelope, bool) {
+	var state protocol.StateData
+	if err := env.Decode(&state); err != nil {
+		log.Printf("unmarshal state error: %v", err)
+		return env, false
+	}
+
+	var players map[string]protocol.PlayerInfo
+	if state.IsKeyframe() {
+		players = protocol.PlayerMap(state.Players)
+	} else {
+		base, ok := n.snapshots[state.Base]
+		if !ok {
+			// The next keyframe will resynchronise us.
+			log.Printf("state delta against unknown base %d", state.Base)
+			return env, false
+		}
+		players = protocol.Patch(base, state)
+	}
+
+	if state.Tick != 0 {
+		n.snapshots[state.Tick] = players
+		for tick := range n.snapshots {
+			if state.Tick-tick >= snapshotHistory {
+				delete(n.snapshots, tick)
+			}
+		}
+		if err := n.write(ctx, conn, protocol.MsgAck, protocol.AckData{Tick: state.Tick}); err != nil {
+			log.Printf("write ack error: %v", err)
+		}
+	}
+
+	full, err := protocol.NewEnvelope(protocol.MsgState, protocol.StateData{
+		Tick: state.Tick, Players: protocol.PlayerList(players), Time: state.Time,
+	})
+	if err != nil {
+		log.Printf("marshal state error: %v", err)
+		return env, false
+	}
+	return full, true
+}
+
 // IsConnected reports whether the WebSocket is open.
 func (n *Network) IsConnected() bool {
 	n.mu.Lock()
@@ -145,6 +397,22 @@ func (n *Network) IsConnected() bool {
 	return n.connected
 }
 
+// Fatal returns a message for the user if the server rejected the client
+// permanently, or "" otherwise.
+func (n *Network) Fatal() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.fatal
+}
+
+// CloseReason returns why the server closed the last connection, or "" if
+// it gave no reason or the client is connected again.
+func (n *Network) CloseReason() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closeReason
+}
+
 // PlayerID returns the server-assigned player ID (empty until welcome received).
 func (n *Network) PlayerID() string {
 	n.mu.Lock()
@@ -159,8 +427,38 @@ func (n *Network) PlayerColor() protocol.Color {
 	return n.playerColor
 }
 
-// SendPosition sends a position update to the server.
-func (n *Network) SendPosition(x, y float64) {
+// RTT returns the round-trip time to the server, or zero until measured.
+func (n *Network) RTT() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.rtt
+}
+
+// Room returns the code of the room the client is in (empty in the lobby).
+func (n *Network) Room() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.room
+}
+
+// Name returns the display name we asked for.
+func (n *Network) Name() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.name
+}
+
+// SetName asks the server for a display name. The server answers with
+// MsgNameResult.
+func (n *Network) SetName(name string) {
+	n.mu.Lock()
+	n.name = name
+	n.mu.Unlock()
+	n.send(protocol.MsgSetName, protocol.SetNameData{Name: name})
+}
+
+// send writes a message to the server if connected.
+func (n *Network) send(msgType protocol.MessageType, data interface{}) {
 	n.mu.Lock()
 	conn := n.conn
 	connected := n.connected
@@ -168,18 +466,42 @@ func (n *Network) SendPosition(x, y float64) {
 	if !connected || conn == nil {
 		return
 	}
-	data, err := protocol.Marshal(protocol.MsgPosition, protocol.PositionData{X: x, Y: y})
-	if err != nil {
-		log.Printf("marshal position error: %v", err)
-		return
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := conn.Write(ctx, websocket.MessageText, data); err != nil {
-		log.Printf("write position error: %v", err)
+	if err := n.write(context.Background(), conn, msgType, data); err != nil {
+		log.Printf("write %s error: %v", msgType, err)
 	}
 }
 
+// SendInput sends one frame of input to the server.
+func (n *Network) SendInput(in protocol.InputData) {
+	n.send(protocol.MsgInput, in)
+}
+
+// SendChat sends a chat line to everyone in the room.
+func (n *Network) SendChat(text string) {
+	n.send(protocol.MsgChat, protocol.ChatData{Text: text})
+}
+
+// SendCommand runs a slash command; line omits the slash. The result arrives
+// as MsgCommandResult.
+func (n *Network) SendCommand(line string) {
+	n.send(protocol.MsgCommand, protocol.CommandData{Line: line})
+}
+
+// ListRooms asks the server for the public rooms.
+func (n *Network) ListRooms() {
+	n.send(protocol.MsgListRooms, struct{}{})
+}
+
+// CreateRoom asks the server to create a room and enter it.
+func (n *Network) CreateRoom(private bool) {
+	n.send(protocol.MsgCreateRoom, protocol.CreateRoomData{Private: private})
+}
+
+// JoinRoom asks the server to enter the room with the given code.
+func (n *Network) JoinRoom(code string) {
+	n.send(protocol.MsgJoinRoom, protocol.JoinRoomData{Code: code})
+}
+
 // ReceiveMessages drains all queued messages and returns them.
 func (n *Network) ReceiveMessages() []protocol.Envelope {
 	var msgs []protocol.Envelope
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"ebiten-fullstack-template/internal/protocol"
+	"ebiten-fullstack-template/internal/sim"
+)
+
+// maxPendingInputs bounds the unacknowledged inputs kept for replay.
+const maxPendingInputs = 4 * sim.FrameRate
+
+// predictor implements client-side prediction: local inputs are applied
+// immediately and kept until the server acknowledges them, so that every
+// authoritative state can be corrected by replaying the inputs it has not
+// seen yet.
+type predictor struct {
+	// seq is the sequence number of the last input sent to the server.
+	seq uint32
+
+	// pending holds sent inputs the server has not acknowledged, oldest first.
+	pending []protocol.InputData
+}
+
+// predict stamps in with the next sequence number, records it for replay and
+// returns the stamped input.
+func (p *predictor) predict(in protocol.InputData) protocol.InputData {
+	p.seq++
+	in.Seq = p.seq
+	if len(p.pending) >= maxPendingInputs {
+		p.pending = p.pending[1:]
+	}
+	p.pending = append(p.pending, in)
+	return in
+}
+
+// reconcile takes the authoritative position (x, y) after the server applied
+// input ack, drops acknowledged inputs and replays the rest on top of it.
+func (p *predictor) reconcile(x, y float64, ack uint32) (float64, float64) {
+	n := 0
+	for n < len(p.pending) && p.pending[n].Seq <= ack {
+		n++
+	}
+	p.pending = p.pending[n:]
+	for _, in := range p.pending {
+		x, y = sim.Step(x, y, in)
+	}
+	return x, y
+}
+
+// reset forgets all inputs; used when a new connection starts a new sequence.
+func (p *predictor) reset() {
+	p.seq = 0
+	p.pending = nil
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// textInput is a single-line text field. It reads typed characters through
+// ebiten.AppendInputChars, which also receives keyboard input in the browser.
+type textInput struct {
+	text []rune
+	max  int
+
+	// filter maps or rejects typed characters; nil accepts everything.
+	filter func(r rune) (rune, bool)
+
+	frames int
+}
+
+// update applies this frame's typed characters and editing keys. It reports
+// whether Enter was pressed to submit the text.
+func (t *textInput) update() bool {
+	t.frames++
+	for _, r := range ebiten.AppendInputChars(nil) {
+		if t.filter != nil {
+			var ok bool
+			if r, ok = t.filter(r); !ok {
+				continue
+			}
+		}
+		if len(t.text) < t.max {
+			t.text = append(t.text, r)
+		}
+	}
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(t.text) > 0 {
+		t.text = t.text[:len(t.text)-1]
+	}
+	return inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter)
+}
+
+// String returns the current text.
+func (t *textInput) String() string {
+	return string(t.text)
+}
+
+// reset clears the text.
+func (t *textInput) reset() {
+	t.text = t.text[:0]
+	t.frames = 0
+}
+
+// draw renders prompt, the text and a blinking cursor at (x, y).
+func (t *textInput) draw(screen *ebiten.Image, prompt string, x, y int) {
+	cursor := ""
+	if t.frames/30%2 == 0 {
+		cursor = "_"
+	}
+	ebitenutil.DebugPrintAt(screen, prompt+string(t.text)+cursor, x, y)
+}
+
+// repeatingKeyPressed reports whether key was just pressed or has been held
+// long enough to auto-repeat this frame.
+func repeatingKeyPressed(key ebiten.Key) bool {
+	const (
+		delay    = 30
+		interval = 3
+	)
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || (d >= delay && (d-delay)%interval == 0)
+}
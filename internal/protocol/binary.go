@@ -0,0 +1,322 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// floatScale is the fixed-point resolution of floats in binary frames. The
+// protocol only carries positions as floats, so 1/16 px is plenty.
+const floatScale = 16
+
+// wireTypes assigns each message type a one-byte id in binary frames; the
+// id is the index plus one. Types missing here are sent by name after a
+// zero id, so append new types to keep frames compact. MessageType.Known
+// relies on every type defined in messages.go being listed.
+var wireTypes = []MessageType{
+	MsgWelcome,
+	MsgJoin,
+	MsgLeave,
+	MsgInput,
+	MsgState,
+	MsgAck,
+	MsgHello,
+	MsgListRooms,
+	MsgRoomList,
+	MsgCreateRoom,
+	MsgJoinRoom,
+	MsgJoinResult,
+	MsgSetName,
+	MsgNameResult,
+	MsgChat,
+	MsgChatHistory,
+	MsgCommand,
+	MsgCommandResult,
+	MsgPing,
+	MsgPong,
+	MsgTimeSync,
+}
+
+var errTruncated = errors.New("protocol: truncated binary frame")
+
+// binaryCodec encodes a frame as the message type id followed by the data
+// fields in declaration order: integers as (zigzag) varints, floats
+// quantized to 1/floatScale, strings and slices length-prefixed and pointers
+// behind a presence byte. Field names are not sent, so both ends must be
+// built from the same protocol version.
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Binary() bool { return true }
+
+func (binaryCodec) Marshal(msgType MessageType, data interface{}) ([]byte, error) {
+	buf := appendMessageType(make([]byte, 0, 64), msgType)
+	return appendValue(buf, reflect.ValueOf(data))
+}
+
+func (binaryCodec) Unmarshal(b []byte) (Envelope, error) {
+	r := &reader{buf: b}
+	msgType, err := r.messageType()
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Type: msgType, Data: b[r.off:], codec: BinaryCodec}, nil
+}
+
+func (binaryCodec) DecodeData(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("protocol: decode target must be a non-nil pointer")
+	}
+	r := &reader{buf: data}
+	return r.value(rv.Elem())
+}
+
+func appendMessageType(buf []byte, msgType MessageType) []byte {
+	for i, t := range wireTypes {
+		if t == msgType {
+			return binary.AppendUvarint(buf, uint64(i+1))
+		}
+	}
+	buf = binary.AppendUvarint(buf, 0)
+	return appendString(buf, string(msgType))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return binary.AppendVarint(buf, int64(math.Round(v.Float()*floatScale))), nil
+	case reflect.String:
+		return appendString(buf, v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = binary.AppendUvarint(buf, uint64(v.Len()))
+			return append(buf, v.Bytes()...), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		fallthrough
+	case reflect.Array:
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = appendValue(buf, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			if buf, err = appendValue(buf, iter.Key()); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	case reflect.Struct:
+		var err error
+		for i := 0; i < v.NumField(); i++ {
+			if !wireField(v.Type().Field(i)) {
+				continue
+			}
+			if buf, err = appendValue(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("protocol: cannot encode %s in binary", v.Type())
+}
+
+// wireField reports whether a struct field is part of the encoding, following
+// the same rules as encoding/json.
+func wireField(f reflect.StructField) bool {
+	return f.IsExported() && !strings.HasPrefix(f.Tag.Get("json"), "-")
+}
+
+// reader decodes a binary frame.
+type reader struct {
+	buf []byte
+	off int
+}
+
+func (r *reader) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(r.buf[r.off:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.off += n
+	return x, nil
+}
+
+func (r *reader) varint() (int64, error) {
+	x, n := binary.Varint(r.buf[r.off:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.off += n
+	return x, nil
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.off >= len(r.buf) {
+		return 0, errTruncated
+	}
+	b := r.buf[r.off]
+	r.off++
+	return b, nil
+}
+
+// length reads a length prefix, rejecting lengths the frame cannot hold.
+func (r *reader) length() (int, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.buf)-r.off) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (r *reader) bytes() ([]byte, error) {
+	n, err := r.length()
+	if err != nil {
+		return nil, err
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *reader) messageType() (MessageType, error) {
+	id, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if id == 0 {
+		name, err := r.bytes()
+		return MessageType(name), err
+	}
+	if id > uint64(len(wireTypes)) {
+		return "", fmt.Errorf("protocol: unknown message id %d", id)
+	}
+	return wireTypes[id-1], nil
+}
+
+func (r *reader) value(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.byte()
+		v.SetBool(b != 0)
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := r.varint()
+		v.SetInt(x)
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := r.uvarint()
+		v.SetUint(x)
+		return err
+	case reflect.Float32, reflect.Float64:
+		x, err := r.varint()
+		v.SetFloat(float64(x) / floatScale)
+		return err
+	case reflect.String:
+		b, err := r.bytes()
+		v.SetString(string(b))
+		return err
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := r.bytes()
+			v.SetBytes(append([]byte(nil), b...))
+			return err
+		}
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			v.SetZero()
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := r.value(key); err != nil {
+				return err
+			}
+			if err := r.value(elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Pointer:
+		present, err := r.byte()
+		if err != nil || present == 0 {
+			v.SetZero()
+			return err
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := r.value(elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !wireField(v.Type().Field(i)) {
+				continue
+			}
+			if err := r.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("protocol: cannot decode %s from binary", v.Type())
+}
@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// binarySamples holds a payload for every type in wireTypes. Floats are
+// multiples of 1/floatScale so that they survive quantization.
+var binarySamples = map[MessageType]interface{}{
+	MsgWelcome: WelcomeData{ID: "p1", Color: Color{R: 231, G: 76, B: 60}, Token: "secret"},
+	MsgJoin:    JoinData{ID: "p2", Name: "Ada", X: 100.5, Y: -3.25, Color: Color{B: 255}},
+	MsgLeave:   LeaveData{ID: "p2"},
+	MsgInput:   InputData{Seq: 1 << 20, Up: true, Right: true, Pointer: true, TX: 320, TY: 240.0625},
+	MsgState: StateData{
+		Tick: 42, Base: 40,
+		Players: []PlayerInfo{{ID: "p1", Name: "Ada", X: 1, Y: 2, Color: Color{R: 1}, LastSeq: 7}},
+		Changed: []PlayerDelta{
+			{ID: "p1", X: ptr(12.5), LastSeq: ptr(uint32(8))},
+			{ID: "p3", Name: ptr("Bob"), X: ptr(0.0), Y: ptr(-7.75), Color: &Color{G: 9}, LastSeq: ptr(uint32(0))},
+		},
+		Removed: []string{"p2"},
+		Time:    1_700_000_000_000,
+	},
+	MsgAck:           AckData{Tick: 42},
+	MsgHello:         HelloData{Version: Version, Build: "abc123", Features: []string{FeatureDelta}, Token: "secret"},
+	MsgListRooms:     struct{}{},
+	MsgRoomList:      RoomListData{Rooms: []RoomInfo{{Code: "ABCDE", Players: 3}, {Code: "my-room", Players: 0}}},
+	MsgCreateRoom:    CreateRoomData{Private: true},
+	MsgJoinRoom:      JoinRoomData{Code: "ABCDE"},
+	MsgJoinResult:    JoinResultData{OK: false, Error: "no room with code XYZ"},
+	MsgSetName:       SetNameData{Name: "Ada"},
+	MsgNameResult:    NameResultData{OK: true, Name: "Ada"},
+	MsgChat:          ChatData{From: "p1", Name: "Ada", Text: "héllo, wörld", Time: 1_700_000_000_123},
+	MsgChatHistory:   ChatHistoryData{Messages: []ChatData{{Text: "server notice"}, {From: "p1", Text: "hi"}}},
+	MsgCommand:       CommandData{Line: "color red"},
+	MsgCommandResult: CommandResultData{OK: true, Text: "line one\nline two"},
+	MsgPing:          PingData{Seq: 3, RTT: 48},
+	MsgPong:          PongData{Seq: 3},
+	MsgTimeSync:      TimeSyncData{Client: 1_700_000_000_000, Server: 1_700_000_000_017},
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// decodeBinary decodes frame into a new value of sample's type.
+func decodeBinary(frame []byte, sample interface{}) (MessageType, interface{}, error) {
+	env, err := BinaryCodec.Unmarshal(frame)
+	if err != nil {
+		return "", nil, err
+	}
+	v := reflect.New(reflect.TypeOf(sample))
+	if err := env.Decode(v.Interface()); err != nil {
+		return env.Type, nil, err
+	}
+	return env.Type, v.Elem().Interface(), nil
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, msgType := range wireTypes {
+		sample, ok := binarySamples[msgType]
+		if !ok {
+			t.Errorf("no sample for %s", msgType)
+			continue
+		}
+		frame, err := BinaryCodec.Marshal(msgType, sample)
+		if err != nil {
+			t.Errorf("marshal %s: %v", msgType, err)
+			continue
+		}
+		gotType, got, err := decodeBinary(frame, sample)
+		if err != nil {
+			t.Errorf("decode %s: %v", msgType, err)
+			continue
+		}
+		if gotType != msgType {
+			t.Errorf("decoded type %s, want %s", gotType, msgType)
+		}
+		if !reflect.DeepEqual(got, sample) {
+			t.Errorf("%s round trip:\n got %+v\nwant %+v", msgType, got, sample)
+		}
+	}
+}
+
+func TestBinaryUnlistedType(t *testing.T) {
+	frame, err := BinaryCodec.Marshal("custom", LeaveData{ID: "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotType, got, err := decodeBinary(frame, LeaveData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotType != "custom" || got != (LeaveData{ID: "p1"}) {
+		t.Errorf("got %s %+v, want custom {ID:p1}", gotType, got)
+	}
+}
+
+func TestBinarySkipsIgnoredFields(t *testing.T) {
+	type withIgnored struct {
+		A       int
+		Skipped string `json:"-"`
+		hidden  int
+		B       string `json:"b,omitempty"`
+	}
+	type withoutIgnored struct {
+		A int
+		B string
+	}
+	in := withIgnored{A: -5, Skipped: "local only", hidden: 9, B: "kept"}
+	frame, err := BinaryCodec.Marshal(MsgChat, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := BinaryCodec.Marshal(MsgChat, withoutIgnored{A: -5, B: "kept"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame, plain) {
+		t.Errorf("ignored fields were encoded: % x, want % x", frame, plain)
+	}
+
+	_, got, err := decodeBinary(frame, withIgnored{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (withIgnored{A: -5, B: "kept"}); got != want {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestBinaryPointers(t *testing.T) {
+	for _, d := range []PlayerDelta{
+		{ID: "nil"},
+		{ID: "zero", Name: ptr(""), X: ptr(0.0), Y: ptr(0.0), Color: &Color{}, LastSeq: ptr(uint32(0))},
+		{ID: "set", Name: ptr("Ada"), Y: ptr(3.5), Color: &Color{R: 1, G: 2, B: 3}},
+	} {
+		frame, err := BinaryCodec.Marshal(MsgState, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, got, err := decodeBinary(frame, PlayerDelta{})
+		if err != nil {
+			t.Fatalf("%s: %v", d.ID, err)
+		}
+		if !reflect.DeepEqual(got, d) {
+			t.Errorf("%s: got %+v, want %+v", d.ID, got, d)
+		}
+	}
+}
+
+func TestBinaryTruncatedFrames(t *testing.T) {
+	for _, msgType := range wireTypes {
+		sample := binarySamples[msgType]
+		frame, err := BinaryCodec.Marshal(msgType, sample)
+		if err != nil {
+			t.Fatalf("marshal %s: %v", msgType, err)
+		}
+		for n := range len(frame) {
+			if _, _, err := decodeBinary(frame[:n], sample); err == nil {
+				t.Errorf("%s truncated to %d of %d bytes decoded without error", msgType, n, len(frame))
+			}
+		}
+	}
+}
+
+func TestBinaryOversizedLengths(t *testing.T) {
+	huge := binary.AppendUvarint(nil, 1<<40)
+	frame := func(msgType MessageType, payload ...byte) []byte {
+		return append(appendMessageType(nil, msgType), payload...)
+	}
+	tests := []struct {
+		name   string
+		frame  []byte
+		sample interface{}
+	}{
+		{"string", frame(MsgLeave, append(huge, 'x')...), LeaveData{}},
+		{"slice", frame(MsgChatHistory, append(huge, 0)...), ChatHistoryData{}},
+		{"slice of structs", frame(MsgState, append([]byte{1, 0}, append(huge, 0)...)...), StateData{}},
+		{"type name", append([]byte{0}, append(huge, 'x')...), LeaveData{}},
+		{"unknown type id", binary.AppendUvarint(nil, uint64(len(wireTypes)+1)), LeaveData{}},
+		{"overlong varint", frame(MsgAck, bytes.Repeat([]byte{0xff}, 11)...), AckData{}},
+	}
+	for _, tt := range tests {
+		if _, _, err := decodeBinary(tt.frame, tt.sample); err == nil {
+			t.Errorf("%s: decoded without error", tt.name)
+		}
+	}
+}
+
+func TestBinaryDecodeTarget(t *testing.T) {
+	frame, err := BinaryCodec.Marshal(MsgLeave, LeaveData{ID: "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := BinaryCodec.Unmarshal(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var leave LeaveData
+	if err := env.Decode(leave); err == nil {
+		t.Error("decoding into a non-pointer succeeded")
+	}
+	if err := env.Decode((*LeaveData)(nil)); err == nil {
+		t.Error("decoding into a nil pointer succeeded")
+	}
+}
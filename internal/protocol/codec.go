@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Codec encodes and decodes protocol messages for the wire. The codec is
+// negotiated per connection; JSON stays available for debugging.
+type Codec interface {
+	// Name identifies the codec during connection negotiation.
+	Name() string
+
+	// Binary reports whether frames are sent as binary WebSocket messages
+	// rather than text.
+	Binary() bool
+
+	// Marshal encodes a typed protocol message into a frame.
+	Marshal(msgType MessageType, data interface{}) ([]byte, error)
+
+	// Unmarshal decodes a frame into an Envelope whose Data is still in this
+	// codec's encoding; use Envelope.Decode to decode it.
+	Unmarshal(b []byte) (Envelope, error)
+
+	// DecodeData decodes an envelope payload produced by this codec into v.
+	DecodeData(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes messages as JSON text frames.
+	JSONCodec Codec = jsonCodec{}
+
+	// BinaryCodec encodes messages as compact binary frames.
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// CodecByName returns the codec with the given name.
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range []Codec{JSONCodec, BinaryCodec} {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// subprotocolPrefix namespaces the WebSocket subprotocol of each codec.
+const subprotocolPrefix = "eft."
+
+// Subprotocol returns the WebSocket subprotocol that selects codec, e.g.
+// "eft.binary".
+func Subprotocol(codec Codec) string {
+	return subprotocolPrefix + codec.Name()
+}
+
+// Subprotocols returns the subprotocols of all codecs.
+func Subprotocols() []string {
+	return []string{Subprotocol(BinaryCodec), Subprotocol(JSONCodec)}
+}
+
+// CodecBySubprotocol returns the codec selected by a negotiated subprotocol.
+func CodecBySubprotocol(subprotocol string) (Codec, bool) {
+	name, ok := strings.CutPrefix(subprotocol, subprotocolPrefix)
+	if !ok {
+		return nil, false
+	}
+	return CodecByName(name)
+}
+
+// jsonCodec is the human-readable codec: an Envelope with a JSON payload.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(msgType MessageType, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: msgType, Data: raw})
+}
+
+func (jsonCodec) Unmarshal(b []byte) (Envelope, error) {
+	var env Envelope
+	err := json.Unmarshal(b, &env)
+	env.codec = JSONCodec
+	return env, err
+}
+
+func (jsonCodec) DecodeData(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
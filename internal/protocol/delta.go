@@ -0,0 +1,85 @@
+package protocol
+
+// Diff returns the changes that turn the player set base into cur.
+func Diff(base, cur map[string]PlayerInfo) (changed []PlayerDelta, removed []string) {
+	for id, p := range cur {
+		old, ok := base[id]
+		d := PlayerDelta{ID: id}
+		dirty := false
+		if !ok || old.Name != p.Name {
+			d.Name, dirty = &p.Name, true
+		}
+		if !ok || old.X != p.X {
+			d.X, dirty = &p.X, true
+		}
+		if !ok || old.Y != p.Y {
+			d.Y, dirty = &p.Y, true
+		}
+		if !ok || old.Color != p.Color {
+			d.Color, dirty = &p.Color, true
+		}
+		if !ok || old.LastSeq != p.LastSeq {
+			d.LastSeq, dirty = &p.LastSeq, true
+		}
+		if dirty {
+			changed = append(changed, d)
+		}
+	}
+	for id := range base {
+		if _, ok := cur[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	return changed, removed
+}
+
+// Patch applies a delta snapshot to the player set base and returns the
+// resulting full player set. base is not modified.
+func Patch(base map[string]PlayerInfo, s StateData) map[string]PlayerInfo {
+	out := make(map[string]PlayerInfo, len(base)+len(s.Changed))
+	for id, p := range base {
+		out[id] = p
+	}
+	for _, id := range s.Removed {
+		delete(out, id)
+	}
+	for _, d := range s.Changed {
+		p := out[d.ID]
+		p.ID = d.ID
+		if d.Name != nil {
+			p.Name = *d.Name
+		}
+		if d.X != nil {
+			p.X = *d.X
+		}
+		if d.Y != nil {
+			p.Y = *d.Y
+		}
+		if d.Color != nil {
+			p.Color = *d.Color
+		}
+		if d.LastSeq != nil {
+			p.LastSeq = *d.LastSeq
+		}
+		out[d.ID] = p
+	}
+	return out
+}
+
+// PlayerMap indexes a player list by ID.
+func PlayerMap(players []PlayerInfo) map[string]PlayerInfo {
+	m := make(map[string]PlayerInfo, len(players))
+	for _, p := range players {
+		m[p.ID] = p
+	}
+	return m
+}
+
+// PlayerList flattens a player set into a list.
+func PlayerList(m map[string]PlayerInfo) []PlayerInfo {
+	players := make([]PlayerInfo, 0, len(m))
+	for _, p := range m {
+		players = append(players, p)
+	}
+	return players
+}
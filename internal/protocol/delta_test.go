@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffPatch(t *testing.T) {
+	red, blue := Color{R: 231, G: 76, B: 60}, Color{R: 52, G: 152, B: 219}
+	ada := PlayerInfo{ID: "p1", Name: "Ada", X: 10, Y: 20, Color: red, LastSeq: 5}
+	bob := PlayerInfo{ID: "p2", Name: "Bob", X: 30, Y: 40, Color: blue, LastSeq: 9}
+	with := func(p PlayerInfo, f func(*PlayerInfo)) PlayerInfo {
+		f(&p)
+		return p
+	}
+
+	tests := []struct {
+		name        string
+		base, cur   []PlayerInfo
+		wantChanged []PlayerDelta
+		wantRemoved []string
+	}{
+		{
+			name: "unchanged",
+			base: []PlayerInfo{ada, bob},
+			cur:  []PlayerInfo{ada, bob},
+		},
+		{
+			name: "added",
+			base: []PlayerInfo{ada},
+			cur:  []PlayerInfo{ada, bob},
+			wantChanged: []PlayerDelta{{
+				ID: "p2", Name: ptr("Bob"), X: ptr(30.0), Y: ptr(40.0), Color: &blue, LastSeq: ptr(uint32(9)),
+			}},
+		},
+		{
+			name:        "removed",
+			base:        []PlayerInfo{ada, bob},
+			cur:         []PlayerInfo{bob},
+			wantRemoved: []string{"p1"},
+		},
+		{
+			name: "moved",
+			base: []PlayerInfo{ada, bob},
+			cur: []PlayerInfo{with(ada, func(p *PlayerInfo) {
+				p.X, p.LastSeq = 12.5, 6
+			}), bob},
+			wantChanged: []PlayerDelta{{ID: "p1", X: ptr(12.5), LastSeq: ptr(uint32(6))}},
+		},
+		{
+			name:        "recoloured",
+			base:        []PlayerInfo{ada},
+			cur:         []PlayerInfo{with(ada, func(p *PlayerInfo) { p.Color = blue })},
+			wantChanged: []PlayerDelta{{ID: "p1", Color: &blue}},
+		},
+		{
+			name:        "renamed to empty",
+			base:        []PlayerInfo{ada},
+			cur:         []PlayerInfo{with(ada, func(p *PlayerInfo) { p.Name = "" })},
+			wantChanged: []PlayerDelta{{ID: "p1", Name: ptr("")}},
+		},
+		{
+			name: "everyone replaced",
+			base: []PlayerInfo{ada},
+			cur:  []PlayerInfo{bob},
+			wantChanged: []PlayerDelta{{
+				ID: "p2", Name: ptr("Bob"), X: ptr(30.0), Y: ptr(40.0), Color: &blue, LastSeq: ptr(uint32(9)),
+			}},
+			wantRemoved: []string{"p1"},
+		},
+		{
+			name:        "from empty base",
+			cur:         []PlayerInfo{with(ada, func(p *PlayerInfo) { p.X, p.Y, p.Name, p.LastSeq = 0, 0, "", 0 })},
+			wantChanged: []PlayerDelta{{ID: "p1", Name: ptr(""), X: ptr(0.0), Y: ptr(0.0), Color: &red, LastSeq: ptr(uint32(0))}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, cur := PlayerMap(tt.base), PlayerMap(tt.cur)
+			changed, removed := Diff(base, cur)
+			slices.SortFunc(changed, func(a, b PlayerDelta) int { return cmp.Compare(a.ID, b.ID) })
+			slices.Sort(removed)
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("changed = %s, want %s", deltaString(changed), deltaString(tt.wantChanged))
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+
+			baseCopy := PlayerMap(tt.base)
+			got := Patch(base, StateData{Tick: 2, Base: 1, Changed: changed, Removed: removed})
+			if !reflect.DeepEqual(got, cur) {
+				t.Errorf("Patch = %v, want %v", got, cur)
+			}
+			if !reflect.DeepEqual(base, baseCopy) {
+				t.Error("Patch modified its base")
+			}
+		})
+	}
+}
+
+// deltaString formats deltas with their pointer fields dereferenced.
+func deltaString(ds []PlayerDelta) string {
+	var parts []string
+	for _, d := range ds {
+		part := "{" + d.ID
+		if d.Name != nil {
+			part += fmt.Sprintf(" name=%q", *d.Name)
+		}
+		if d.X != nil {
+			part += fmt.Sprintf(" x=%g", *d.X)
+		}
+		if d.Y != nil {
+			part += fmt.Sprintf(" y=%g", *d.Y)
+		}
+		if d.Color != nil {
+			part += fmt.Sprintf(" color=%v", *d.Color)
+		}
+		if d.LastSeq != nil {
+			part += fmt.Sprintf(" seq=%d", *d.LastSeq)
+		}
+		parts = append(parts, part+"}")
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
@@ -1,11 +1,66 @@
 package protocol
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Version is the wire protocol version. Bump it on every incompatible change
+// to the messages, including field order and new message types (the binary
+// codec depends on both), and list the change below.
+//
+//	1  hello handshake
+//	2  room lobby messages
+//	3  session tokens in hello and welcome
+//	4  player names in joins and snapshots
+//	5  chat messages
+//	6  slash commands
+//	7  heartbeat pings
+//	8  clock sync and snapshot times
+const Version = 8
+
+// BuildHash identifies the build. It is set at link time with
+// -ldflags "-X ebiten-fullstack-template/internal/protocol.BuildHash=...".
+var BuildHash = "dev"
+
+// Optional protocol features negotiated in the hello exchange.
+const (
+	// FeatureDelta lets the server send delta state snapshots.
+	FeatureDelta = "delta"
+)
+
+// Features lists the optional features this build supports.
+var Features = []string{FeatureDelta}
+
+// CloseIncompatible is the WebSocket close code the server uses to reject a
+// client whose protocol version it does not speak. The client should ask the
+// user to reload the page to fetch the current build.
+const CloseIncompatible = 4001
+
+// CloseSlowConsumer is the WebSocket close code the server uses to drop a
+// client that did not read its messages fast enough. Its player has left the
+// room; the client may reconnect.
+const CloseSlowConsumer = 4002
+
+// CloseKicked is the WebSocket close code the server uses for a client that
+// was kicked from its room, and to turn it away should it come back soon.
+// The client should return to the lobby rather than rejoin the room.
+const CloseKicked = 4003
 
 // MessageType discriminates protocol messages.
 type MessageType string
 
+// Known reports whether t is one of the message types defined here.
+func (t MessageType) Known() bool {
+	return slices.Contains(wireTypes, t)
+}
+
 const (
+	// MsgHello is the first message in each direction. The client announces
+	// its protocol version and features; the server answers with its own
+	// version and the features both sides support.
+	MsgHello MessageType = "hello"
+
 	// MsgWelcome is sent from server to the newly connected client with their
 	// assigned ID and color.
 	MsgWelcome MessageType = "welcome"
@@ -16,17 +71,83 @@ const (
 	// MsgLeave is broadcast by the server when a player disconnects.
 	MsgLeave MessageType = "leave"
 
-	// MsgPosition is sent from client to server with the player's current position.
-	MsgPosition MessageType = "position"
+	// MsgInput is sent from client to server with one frame of player input.
+	MsgInput MessageType = "input"
 
-	// MsgState is broadcast by the server with the full game state (all players).
+	// MsgState is sent by the server every tick with the game state, either
+	// as a full keyframe or as a delta against an acknowledged snapshot.
 	MsgState MessageType = "state"
+
+	// MsgListRooms is sent from a client in the lobby to request MsgRoomList.
+	MsgListRooms MessageType = "list_rooms"
+
+	// MsgRoomList answers MsgListRooms with the public rooms.
+	MsgRoomList MessageType = "room_list"
+
+	// MsgCreateRoom is sent from a client in the lobby to create a room with
+	// a fresh join code and enter it.
+	MsgCreateRoom MessageType = "create_room"
+
+	// MsgJoinRoom is sent from a client in the lobby to enter a room by code.
+	MsgJoinRoom MessageType = "join_room"
+
+	// MsgJoinResult answers MsgCreateRoom and MsgJoinRoom. On success it is
+	// followed by MsgWelcome from the room.
+	MsgJoinResult MessageType = "join_result"
+
+	// MsgSetName is sent from client to server to pick a display name.
+	MsgSetName MessageType = "set_name"
+
+	// MsgNameResult answers MsgSetName.
+	MsgNameResult MessageType = "name_result"
+
+	// MsgChat is sent from client to server with a chat line, and broadcast
+	// by the server to everyone in the room with the sender filled in.
+	MsgChat MessageType = "chat"
+
+	// MsgChatHistory is sent by the server to a player entering a room with
+	// the most recent chat lines.
+	MsgChatHistory MessageType = "chat_history"
+
+	// MsgCommand is sent from client to server with a slash command line.
+	MsgCommand MessageType = "command"
+
+	// MsgCommandResult answers MsgCommand, privately to the issuing client.
+	MsgCommandResult MessageType = "command_result"
+
+	// MsgPing is sent periodically from server to client, which must answer
+	// with MsgPong at once. Clients that stop answering are disconnected.
+	MsgPing MessageType = "ping"
+
+	// MsgPong answers MsgPing.
+	MsgPong MessageType = "pong"
+
+	// MsgTimeSync is sent from client to server with the client's clock and
+	// echoed back with the server's, to estimate the server clock.
+	MsgTimeSync MessageType = "time_sync"
+
+	// MsgAck is sent from client to server to acknowledge the newest state
+	// snapshot it has applied, which becomes the base for future deltas.
+	MsgAck MessageType = "ack"
 )
 
 // Envelope wraps every protocol message with a type discriminator.
 type Envelope struct {
-	Type MessageType     `json:"type"`
+	Type MessageType `json:"type"`
+
+	// Data is the message payload in the encoding of the codec that
+	// produced the envelope; decode it with Decode.
 	Data json.RawMessage `json:"data"`
+
+	codec Codec
+}
+
+// Decode decodes the envelope payload into the *Data struct v.
+func (e Envelope) Decode(v interface{}) error {
+	if e.codec == nil {
+		return json.Unmarshal(e.Data, v)
+	}
+	return e.codec.DecodeData(e.Data, v)
 }
 
 // Color represents an RGB color.
@@ -36,15 +157,41 @@ type Color struct {
 	B uint8 `json:"b"`
 }
 
+// HelloData is exchanged before MsgWelcome.
+type HelloData struct {
+	Version  int      `json:"version"`
+	Build    string   `json:"build"`
+	Features []string `json:"features,omitempty"`
+
+	// Token is the session token from a previous MsgWelcome, sent by a
+	// reconnecting client to get its player back.
+	Token string `json:"token,omitempty"`
+}
+
+// Has reports whether feature is among the announced features.
+func (h HelloData) Has(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
 // WelcomeData is sent to a newly connected client.
 type WelcomeData struct {
 	ID    string `json:"id"`
 	Color Color  `json:"color"`
+
+	// Token resumes this player's session when sent back in HelloData
+	// after a reconnect.
+	Token string `json:"token,omitempty"`
 }
 
 // JoinData is broadcast when a new player joins.
 type JoinData struct {
 	ID    string  `json:"id"`
+	Name  string  `json:"name,omitempty"`
 	X     float64 `json:"x"`
 	Y     float64 `json:"y"`
 	Color Color   `json:"color"`
@@ -55,38 +202,188 @@ type LeaveData struct {
 	ID string `json:"id"`
 }
 
-// PositionData is sent by a client to update their position.
-type PositionData struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
+// InputData is one frame of player input. The server never trusts client
+// positions; it replays inputs with the same movement rules as the client.
+type InputData struct {
+	// Seq increases by one for every input a client sends.
+	Seq uint32 `json:"seq"`
+
+	Up    bool `json:"up,omitempty"`
+	Down  bool `json:"down,omitempty"`
+	Left  bool `json:"left,omitempty"`
+	Right bool `json:"right,omitempty"`
+
+	// Pointer is set while the mouse or a touch is held; the player then
+	// moves toward (TX, TY).
+	Pointer bool    `json:"pointer,omitempty"`
+	TX      float64 `json:"tx,omitempty"`
+	TY      float64 `json:"ty,omitempty"`
+}
+
+// IsIdle reports whether the input would not move the player.
+func (in InputData) IsIdle() bool {
+	return !in.Up && !in.Down && !in.Left && !in.Right && !in.Pointer
 }
 
 // PlayerInfo describes a single player inside a state snapshot.
 type PlayerInfo struct {
 	ID    string  `json:"id"`
+	Name  string  `json:"name,omitempty"`
 	X     float64 `json:"x"`
 	Y     float64 `json:"y"`
 	Color Color   `json:"color"`
+
+	// LastSeq acknowledges the last InputData.Seq applied for this player.
+	LastSeq uint32 `json:"seq,omitempty"`
 }
 
-// StateData contains the complete game state broadcast to all clients.
+// PlayerDelta carries the fields of a player that changed since a base
+// snapshot. Nil fields are unchanged; players new since the base have every
+// field set.
+type PlayerDelta struct {
+	ID      string   `json:"id"`
+	Name    *string  `json:"name,omitempty"`
+	X       *float64 `json:"x,omitempty"`
+	Y       *float64 `json:"y,omitempty"`
+	Color   *Color   `json:"color,omitempty"`
+	LastSeq *uint32  `json:"seq,omitempty"`
+}
+
+// StateData contains a game state snapshot.
+//
+// A keyframe (Base == 0) lists every player in Players. A delta lists only
+// the players that changed (Changed) or left (Removed) since the snapshot
+// numbered Base, which the client has acknowledged.
 type StateData struct {
-	Players []PlayerInfo `json:"players"`
+	// Tick numbers the snapshot; zero snapshots cannot be acknowledged.
+	Tick uint32 `json:"tick,omitempty"`
+	Base uint32 `json:"base,omitempty"`
+
+	Players []PlayerInfo  `json:"players,omitempty"`
+	Changed []PlayerDelta `json:"changed,omitempty"`
+	Removed []string      `json:"removed,omitempty"`
+
+	// Time is the server clock when the snapshot was taken, in Unix
+	// milliseconds.
+	Time int64 `json:"time,omitempty"`
+}
+
+// IsKeyframe reports whether the snapshot carries the full state.
+func (s StateData) IsKeyframe() bool {
+	return s.Base == 0
+}
+
+// SetNameData asks for a display name.
+type SetNameData struct {
+	Name string `json:"name"`
+}
+
+// NameResultData reports whether the requested name was taken. On success
+// Name is the name as stored, after normalisation.
+type NameResultData struct {
+	OK    bool   `json:"ok"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ChatData is a chat line. Clients only fill in Text; the server sets the
+// rest. An empty From marks a message from the server itself.
+type ChatData struct {
+	From string `json:"from,omitempty"`
+	Name string `json:"name,omitempty"`
+	Text string `json:"text"`
+
+	// Time is when the server accepted the line, in Unix milliseconds.
+	Time int64 `json:"time,omitempty"`
+}
+
+// ChatHistoryData carries recent chat lines, oldest first.
+type ChatHistoryData struct {
+	Messages []ChatData `json:"messages"`
+}
+
+// CommandData is a slash command line without the leading slash, e.g.
+// "color red".
+type CommandData struct {
+	Line string `json:"line"`
+}
+
+// CommandResultData is a command's output. Text may span several lines.
+type CommandResultData struct {
+	OK   bool   `json:"ok"`
+	Text string `json:"text"`
+}
+
+// PingData is a heartbeat. RTT is the round-trip time the server measured
+// with the previous ping, in milliseconds, or zero if not yet known.
+type PingData struct {
+	Seq uint32 `json:"seq"`
+	RTT uint32 `json:"rtt"`
+}
+
+// PongData echoes the sequence number of the ping it answers.
+type PongData struct {
+	Seq uint32 `json:"seq"`
+}
+
+// TimeSyncData is one clock sample. The client sends its clock in Client,
+// in Unix milliseconds; the server echoes it and adds its own in Server.
+type TimeSyncData struct {
+	Client int64 `json:"client"`
+	Server int64 `json:"server,omitempty"`
+}
+
+// AckData acknowledges a state snapshot.
+type AckData struct {
+	Tick uint32 `json:"tick"`
+}
+
+// RoomInfo describes a running room.
+type RoomInfo struct {
+	Code    string `json:"code"`
+	Players int    `json:"players"`
+}
+
+// RoomListData lists the public rooms.
+type RoomListData struct {
+	Rooms []RoomInfo `json:"rooms"`
+}
+
+// CreateRoomData asks for a new room. Private rooms are not listed and can
+// only be entered with their join code.
+type CreateRoomData struct {
+	Private bool `json:"private,omitempty"`
+}
+
+// JoinRoomData asks to enter the room with the given code.
+type JoinRoomData struct {
+	Code string `json:"code"`
+}
+
+// JoinResultData reports whether the client entered a room.
+type JoinResultData struct {
+	OK    bool   `json:"ok"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
 }
 
 // Marshal encodes a typed protocol message into a JSON envelope.
 func Marshal(msgType MessageType, data interface{}) ([]byte, error) {
+	return JSONCodec.Marshal(msgType, data)
+}
+
+// NewEnvelope wraps typed data into an Envelope without serialising the
+// envelope itself, for handing already-decoded messages along.
+func NewEnvelope(msgType MessageType, data interface{}) (Envelope, error) {
 	raw, err := json.Marshal(data)
 	if err != nil {
-		return nil, err
+		return Envelope{}, err
 	}
-	return json.Marshal(Envelope{Type: msgType, Data: raw})
+	return Envelope{Type: msgType, Data: raw}, nil
 }
 
 // Unmarshal decodes a JSON envelope. Callers switch on env.Type and then
-// json.Unmarshal env.Data into the appropriate *Data struct.
+// env.Decode into the appropriate *Data struct.
 func Unmarshal(b []byte) (Envelope, error) {
-	var env Envelope
-	err := json.Unmarshal(b, &env)
-	return env, err
+	return JSONCodec.Unmarshal(b)
 }
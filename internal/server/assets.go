@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"ebiten-fullstack-template/web"
+)
+
+// encodings lists the precompressed variants looked for next to each asset,
+// best first: client.wasm.br, then client.wasm.gz.
+var encodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// assetHandler serves the web client's static files with ETags, revalidation
+// headers and precompressed variants.
+type assetHandler struct {
+	fsys fs.FS
+
+	// etags caches content hashes by file name, modification time and size.
+	etags sync.Map
+}
+
+// newAssetHandler serves the files embedded in the binary, or those in dir if
+// it is set, e.g. to pick up a rebuilt client without restarting.
+func newAssetHandler(dir string) *assetHandler {
+	if dir != "" {
+		return &assetHandler{fsys: os.DirFS(dir)}
+	}
+	return &assetHandler{fsys: web.Files}
+}
+
+func (a *assetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	// The embedded directory is also a Go package; keep its source private.
+	if strings.HasSuffix(name, ".go") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if path.Ext(name) == ".wasm" {
+		ctype = "application/wasm" // required for streaming compilation
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	accept := r.Header.Get("Accept-Encoding")
+	for _, enc := range encodings {
+		if !acceptsEncoding(accept, enc.name) {
+			continue
+		}
+		if a.serveFile(w, r, name+enc.ext, ctype, enc.name) {
+			return
+		}
+	}
+	if !a.serveFile(w, r, name, ctype, "") {
+		http.NotFound(w, r)
+	}
+}
+
+// serveFile serves one file with the given content type and encoding. It
+// reports false if the file does not exist.
+func (a *assetHandler) serveFile(w http.ResponseWriter, r *http.Request, name, ctype, encoding string) bool {
+	f, err := a.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+	etag, err := a.etag(name, fi, content)
+	if err != nil {
+		http.Error(w, "read error", http.StatusInternalServerError)
+		return true
+	}
+
+	h := w.Header()
+	if ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	h.Set("ETag", etag)
+	// File names are not content-hashed and the client must match the
+	// server's protocol version, so browsers revalidate every load; the
+	// ETag makes that a cheap 304.
+	h.Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+	return true
+}
+
+// etag returns the strong ETag of a file, hashing it on first use.
+func (a *assetHandler) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	type key struct {
+		name    string
+		modTime time.Time
+		size    int64
+	}
+	k := key{name, fi.ModTime(), fi.Size()}
+	if etag, ok := a.etags.Load(k); ok {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	a.etags.Store(k, etag)
+	return etag, nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssetHandler(t *testing.T) {
+	a := &assetHandler{fsys: fstest.MapFS{
+		"index.html":         {Data: []byte("<html></html>")},
+		"client.wasm":        {Data: []byte("wasm")},
+		"client.wasm.br":     {Data: []byte("brotli")},
+		"client.wasm.gz":     {Data: []byte("gzip")},
+		"wasm_exec.js":       {Data: []byte("js")},
+		"wasm_exec.js.gz":    {Data: []byte("js gzip")},
+		"embed.go":           {Data: []byte("package web")},
+		"sub/dir/README.txt": {Data: []byte("readme")},
+	}}
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		target       string
+		accept       string
+		wantStatus   int
+		wantBody     string
+		wantType     string
+		wantEncoding string
+	}{
+		{name: "index", target: "/", wantStatus: 200, wantBody: "<html></html>", wantType: mime.TypeByExtension(".html")},
+		{name: "wasm", target: "/client.wasm", wantStatus: 200, wantBody: "wasm", wantType: "application/wasm"},
+		{name: "brotli first", target: "/client.wasm", accept: "gzip, deflate, br", wantStatus: 200,
+			wantBody: "brotli", wantType: "application/wasm", wantEncoding: "br"},
+		{name: "gzip", target: "/client.wasm", accept: "gzip", wantStatus: 200,
+			wantBody: "gzip", wantType: "application/wasm", wantEncoding: "gzip"},
+		{name: "brotli refused", target: "/client.wasm", accept: "br;q=0, gzip;q=0.5", wantStatus: 200,
+			wantBody: "gzip", wantType: "application/wasm", wantEncoding: "gzip"},
+		{name: "everything refused", target: "/client.wasm", accept: "br; q=0.0, gzip;q=0", wantStatus: 200,
+			wantBody: "wasm", wantType: "application/wasm"},
+		{name: "no brotli variant", target: "/wasm_exec.js", accept: "br, gzip", wantStatus: 200,
+			wantBody: "js gzip", wantType: mime.TypeByExtension(".js"), wantEncoding: "gzip"},
+		{name: "go source", target: "/embed.go", wantStatus: 404},
+		{name: "go source via dots", target: "/sub/../embed.go", wantStatus: 404},
+		{name: "missing", target: "/nope.js", wantStatus: 404},
+		{name: "directory", target: "/sub/dir", wantStatus: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.target, "Accept-Encoding", tt.accept)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body %q, want %q", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary %q, want Accept-Encoding", got)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("Cache-Control %q, want no-cache", got)
+			}
+		})
+	}
+
+	t.Run("revalidation", func(t *testing.T) {
+		first := get("/client.wasm", "Accept-Encoding", "br")
+		etag := first.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("no ETag")
+		}
+		if plain := get("/client.wasm").Header().Get("ETag"); plain == etag {
+			t.Error("compressed and plain variants share an ETag")
+		}
+		if rec := get("/client.wasm", "Accept-Encoding", "br", "If-None-Match", etag); rec.Code != http.StatusNotModified {
+			t.Errorf("matching If-None-Match: status %d, want 304", rec.Code)
+		}
+		if rec := get("/client.wasm", "Accept-Encoding", "br", "If-None-Match", `"stale"`); rec.Code != http.StatusOK {
+			t.Errorf("stale If-None-Match: status %d, want 200", rec.Code)
+		}
+	})
+
+	t.Run("method", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("POST: status %d, want 405", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+const (
+	// maxChatLength is the longest chat line accepted, in characters.
+	maxChatLength = 200
+
+	// chatHistorySize is how many recent lines late joiners receive.
+	chatHistorySize = 50
+
+	// Each player may send chatBurst lines at once and chatRate lines per
+	// second after that.
+	chatRate  = 1
+	chatBurst = 5
+)
+
+// handleChat validates a chat line from client and broadcasts it to the
+// room. MUST be called only from Run.
+func (h *Hub) handleChat(client *Client, env protocol.Envelope) {
+	var req protocol.ChatData
+	if err := env.Decode(&req); err != nil {
+		client.logBadMessage("malformed message", "type", env.Type, "err", err)
+		return
+	}
+	text := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, req.Text))
+	if text == "" {
+		return
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		h.sendSystemChat(client, fmt.Sprintf("Message too long (at most %d characters).", maxChatLength))
+		return
+	}
+	if client.chatLimit == nil {
+		client.chatLimit = newTokenBucket(chatRate, chatBurst)
+	}
+	if !client.chatLimit.allow(time.Now()) {
+		h.sendSystemChat(client, "You are sending messages too fast.")
+		return
+	}
+
+	msg := protocol.ChatData{From: client.ID, Text: text, Time: time.Now().UnixMilli()}
+	if ps, ok := h.State.Player(client.ID); ok {
+		msg.Name = ps.Name
+	}
+	if len(h.chatHistory) == chatHistorySize {
+		h.chatHistory = h.chatHistory[1:]
+	}
+	h.chatHistory = append(h.chatHistory, msg)
+	h.broadcastMessage(protocol.MsgChat, msg)
+}
+
+// sendSystemChat sends a chat line from the server to one client only.
+// MUST be called only from Run.
+func (h *Hub) sendSystemChat(client *Client, text string) {
+	h.sendMessage(client, protocol.MsgChat, protocol.ChatData{Text: text, Time: time.Now().UnixMilli()})
+}
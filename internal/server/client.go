@@ -2,8 +2,8 @@ package server
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
@@ -11,95 +11,261 @@ import (
 	"ebiten-fullstack-template/internal/protocol"
 )
 
-const (
-	writeWait      = 10 * time.Second
-	maxMessageSize = 4096
-	sendBufferSize = 256
-)
-
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
-	hub  *Hub
-	conn *websocket.Conn
-	send chan []byte
+	rooms *RoomManager
+	conn  *websocket.Conn
+	send  *sendQueue
+	codec protocol.Codec
+
+	// closing is the status the connection is closed with once the hub
+	// drops the client, or nil for a normal closure.
+	closing atomic.Pointer[closeStatus]
+
+	// hub is the room the client is in, nil while it is in the lobby. Only
+	// the ReadPump goroutine may touch it once the pumps are running.
+	hub *Hub
 
 	// ID is the unique player identifier for this client.
 	ID string
+
+	// remote is the client's IP address, used to keep kicked players out
+	// of the room; "" if unknown.
+	remote string
+
+	// name is the display name chosen in the lobby, applied on joining.
+	name string
+
+	// resumed is set if the client reconnected with a session token and
+	// took over its previous player.
+	resumed bool
+
+	// writeWait bounds each write; readLimit bounds each message read.
+	writeWait time.Duration
+	readLimit int64
+
+	// The client is pinged every pingInterval and disconnected if nothing
+	// arrives for readTimeout.
+	pingInterval time.Duration
+	readTimeout  time.Duration
+
+	// pingSeq and pingSent identify the latest ping; rtt is the last
+	// measured round-trip time in nanoseconds.
+	pingSeq  atomic.Uint32
+	pingSent atomic.Int64
+	rtt      atomic.Int64
+
+	// delta is set if the client accepts delta state snapshots.
+	delta bool
+
+	// ackTick is the newest state snapshot the client has acknowledged.
+	ackTick atomic.Uint32
+
+	// inbound and flood rate-limit messages read from the connection; nil
+	// disables the limit. Owned by the ReadPump goroutine.
+	inbound, flood *tokenBucket
+
+	// baseLog carries the player ID and remote address, log adds the room
+	// once the client enters one. See logger.
+	baseLog *slog.Logger
+	log     atomic.Pointer[slog.Logger]
+
+	// metrics counts messages and throttling.
+	metrics *Metrics
+
+	// chatLimit rate-limits chat lines. Owned by the hub goroutine.
+	chatLimit *tokenBucket
+
+	// lastKeyframe is the tick of the last full snapshot sent. Owned by the
+	// hub goroutine.
+	lastKeyframe uint32
+}
+
+// closeStatus is a WebSocket close code with the reason shown to the player.
+type closeStatus struct {
+	code   websocket.StatusCode
+	reason string
+}
+
+// NewClient creates a new Client in the lobby that speaks the given codec,
+// with buffer sizes and timeouts from the rooms' configuration.
+func NewClient(rooms *RoomManager, conn *websocket.Conn, id string, codec protocol.Codec) *Client {
+	c := &Client{
+		rooms:     rooms,
+		conn:      conn,
+		send:      newSendQueue(rooms.config.SendBufferSize),
+		codec:     codec,
+		ID:        id,
+		metrics:   rooms.metrics,
+		writeWait: rooms.config.WriteWait,
+		readLimit: rooms.config.MaxMessageSize,
+
+		pingInterval: rooms.config.PingInterval,
+		readTimeout:  rooms.config.ReadTimeout,
+	}
+	c.setLogger(slog.With("player", id))
+	return c
+}
+
+// setLogger replaces the client's logger. Must be called before the client
+// enters a room.
+func (c *Client) setLogger(l *slog.Logger) {
+	c.baseLog = l
+	c.log.Store(l)
+}
+
+// closeWith closes the connection with code and reason, for a client the
+// hub is about to drop. It must be called before the hub closes send, so
+// that the WritePump closes with the same status should it get there first.
+func (c *Client) closeWith(code websocket.StatusCode, reason string) {
+	c.closing.Store(&closeStatus{code: code, reason: reason})
+	// Close blocks for the closing handshake; keep the caller running.
+	go c.conn.Close(code, reason)
+}
+
+// frameType returns the WebSocket message type used by the client's codec.
+func (c *Client) frameType() websocket.MessageType {
+	return frameType(c.codec)
 }
 
-// NewClient creates a new Client.
-func NewClient(hub *Hub, conn *websocket.Conn, id string) *Client {
-	return &Client{
-		hub:  hub,
-		conn: conn,
-		send: make(chan []byte, sendBufferSize),
-		ID:   id,
+// frameType returns the WebSocket message type used by codec.
+func frameType(codec protocol.Codec) websocket.MessageType {
+	if codec.Binary() {
+		return websocket.MessageBinary
 	}
+	return websocket.MessageText
 }
 
 // ReadPump pumps messages from the websocket connection to the hub.
 func (c *Client) ReadPump() {
-	log.Printf("[%s] read pump started", c.ID)
+	c.logger().Debug("read pump started")
 	defer func() {
-		log.Printf("[%s] read pump stopped", c.ID)
-		c.hub.unregister <- c
+		c.logger().Debug("read pump stopped")
+		c.metrics.ClientsConnected.Add(-1)
+		if c.hub != nil {
+			c.hub.unregister <- c
+		} else {
+			c.send.close()
+		}
 		_ = c.conn.Close(websocket.StatusNormalClosure, "")
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	ctx := context.Background()
+	c.conn.SetReadLimit(c.readLimit)
 
 	for {
+		// Clients answer every ping, so silence means the peer is gone;
+		// the library closes the connection when the read times out.
+		ctx, cancel := context.WithTimeout(context.Background(), c.readTimeout)
 		msgType, message, err := c.conn.Read(ctx)
+		cancel()
 		if err != nil {
 			if websocket.CloseStatus(err) != websocket.StatusNormalClosure &&
 				websocket.CloseStatus(err) != websocket.StatusGoingAway {
-				log.Printf("websocket error: %v", err)
+				c.logger().Info("websocket read failed", "err", err)
 			}
 			return
 		}
-		if msgType != websocket.MessageText {
+		if drop, flooding := c.throttle(time.Now()); flooding {
+			c.logger().Warn("disconnecting: message rate limit exceeded")
+			c.metrics.ClientsRateLimited.Add(1)
+			_ = c.conn.Close(websocket.StatusPolicyViolation, "message rate limit exceeded")
+			return
+		} else if drop {
+			continue
+		}
+		if msgType != c.frameType() {
 			continue
 		}
 
-		env, err := protocol.Unmarshal(message)
+		env, err := c.codec.Unmarshal(message)
 		if err != nil {
-			log.Printf("unmarshal error from %s: %v", c.ID, err)
+			c.logBadMessage("malformed message", "err", err)
+			continue
+		}
+		c.metrics.messageIn(env.Type)
+
+		switch env.Type {
+		case protocol.MsgPong:
+			c.handlePong(env)
+			continue
+		case protocol.MsgTimeSync:
+			c.handleTimeSync(env)
+			continue
+		}
+		if c.hub == nil {
+			c.handleLobby(env)
 			continue
 		}
 
 		switch env.Type {
-		case protocol.MsgPosition:
-			var pos protocol.PositionData
-			if err := json.Unmarshal(env.Data, &pos); err != nil {
-				log.Printf("unmarshal position error from %s: %v", c.ID, err)
+		case protocol.MsgInput:
+			var in protocol.InputData
+			if err := env.Decode(&in); err != nil {
+				c.logBadMessage("malformed message", "type", env.Type, "err", err)
+				continue
+			}
+			c.hub.inputs <- clientInput{client: c, input: in}
+
+		case protocol.MsgAck:
+			var ack protocol.AckData
+			if err := env.Decode(&ack); err != nil {
+				c.logBadMessage("malformed message", "type", env.Type, "err", err)
 				continue
 			}
-			c.hub.State.UpdatePosition(c.ID, pos.X, pos.Y)
+			c.ackTick.Store(ack.Tick)
 
-			stateMsg := c.hub.buildStateMessage()
-			c.hub.Broadcast(stateMsg)
+		case protocol.MsgSetName, protocol.MsgChat, protocol.MsgCommand:
+			c.hub.messages <- clientMessage{client: c, env: env}
 
 		default:
-			log.Printf("unknown message type from %s: %s", c.ID, env.Type)
+			c.logBadMessage("unknown message type", "type", env.Type)
 		}
 	}
 }
 
 // WritePump pumps messages from the hub to the websocket connection.
 func (c *Client) WritePump() {
-	log.Printf("[%s] write pump started", c.ID)
+	c.logger().Debug("write pump started")
 	defer func() {
-		_ = c.conn.Close(websocket.StatusNormalClosure, "")
-		log.Printf("[%s] write pump stopped", c.ID)
+		if cs := c.closing.Load(); cs != nil {
+			_ = c.conn.Close(cs.code, cs.reason)
+		} else {
+			_ = c.conn.Close(websocket.StatusNormalClosure, "")
+		}
+		c.logger().Debug("write pump stopped")
 	}()
 
-	for message := range c.send {
-		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
-		err := c.conn.Write(ctx, websocket.MessageText, message)
-		cancel()
-		if err != nil {
-			return
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.send.ready:
+			for {
+				message, ok, done := c.send.pop()
+				if done {
+					return
+				}
+				if !ok {
+					break
+				}
+				if c.write(message.data) != nil {
+					return
+				}
+			}
+
+		case <-ticker.C:
+			if err := c.ping(); err != nil {
+				return
+			}
 		}
 	}
 }
+
+// write writes one encoded message to the connection. It is called from
+// WritePump.
+func (c *Client) write(message []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.writeWait)
+	defer cancel()
+	return c.conn.Write(ctx, c.frameType(), message)
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// Permission is the level a player needs to run a command.
+type Permission int
+
+const (
+	// PermPlayer commands are open to everyone in the room.
+	PermPlayer Permission = iota
+
+	// PermOwner commands are reserved for the room owner: the connected
+	// player that has been in the room longest.
+	PermOwner
+)
+
+// CommandContext is what a command runs with. Commands run on the hub
+// goroutine, so they may use the hub's unexported state directly.
+type CommandContext struct {
+	Hub    *Hub
+	Client *Client
+	Args   []string
+}
+
+// Command is a slash command players can run.
+type Command struct {
+	Name  string
+	Usage string
+	Help  string
+	Level Permission
+
+	// MinArgs and MaxArgs bound the number of arguments; MaxArgs < 0 allows
+	// any number.
+	MinArgs, MaxArgs int
+
+	// Run executes the command and returns the text sent back to the
+	// player. An error is sent back as a failure.
+	Run func(ctx *CommandContext) (string, error)
+}
+
+// CommandRegistry holds the commands players can run. It must not be
+// modified once hubs are running.
+type CommandRegistry struct {
+	commands map[string]*Command
+}
+
+// NewCommandRegistry creates an empty registry.
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{commands: make(map[string]*Command)}
+}
+
+// Register adds a command, replacing any command of the same name.
+func (r *CommandRegistry) Register(cmd *Command) {
+	r.commands[cmd.Name] = cmd
+}
+
+// Lookup returns the command with the given name.
+func (r *CommandRegistry) Lookup(name string) (*Command, bool) {
+	cmd, ok := r.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// Available returns the commands a player with level may run, by name.
+func (r *CommandRegistry) Available(level Permission) []*Command {
+	var cmds []*Command
+	for _, cmd := range r.commands {
+		if cmd.Level <= level {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+// Execute parses a command line and runs the command for client.
+func (r *CommandRegistry) Execute(h *Hub, client *Client, line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errors.New("empty command; try /help")
+	}
+	cmd, ok := r.Lookup(fields[0])
+	if !ok {
+		return "", fmt.Errorf("unknown command /%s; try /help", fields[0])
+	}
+	if cmd.Level > h.permission(client) {
+		return "", fmt.Errorf("/%s is for the room owner only", cmd.Name)
+	}
+	args := fields[1:]
+	if len(args) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(args) > cmd.MaxArgs) {
+		return "", fmt.Errorf("usage: %s", cmd.Usage)
+	}
+	return cmd.Run(&CommandContext{Hub: h, Client: client, Args: args})
+}
+
+// runCommand executes a MsgCommand and replies privately to client. MUST be
+// called only from Run.
+func (h *Hub) runCommand(client *Client, env protocol.Envelope) {
+	var req protocol.CommandData
+	if err := env.Decode(&req); err != nil {
+		client.logBadMessage("malformed message", "type", env.Type, "err", err)
+		return
+	}
+	if h.Commands == nil {
+		h.sendMessage(client, protocol.MsgCommandResult, protocol.CommandResultData{Text: "commands are disabled"})
+		return
+	}
+	text, err := h.Commands.Execute(h, client, req.Line)
+	if err != nil {
+		h.sendMessage(client, protocol.MsgCommandResult, protocol.CommandResultData{Text: err.Error()})
+		return
+	}
+	h.sendMessage(client, protocol.MsgCommandResult, protocol.CommandResultData{OK: true, Text: text})
+}
+
+// permission returns the level of client in the room. MUST be called only
+// from Run.
+func (h *Hub) permission(client *Client) Permission {
+	if client.ID == h.owner() {
+		return PermOwner
+	}
+	return PermPlayer
+}
+
+// colorNames maps /color names onto the player palette.
+var colorNames = map[string]protocol.Color{
+	"red":    playerColors[0],
+	"green":  playerColors[1],
+	"blue":   playerColors[2],
+	"purple": playerColors[3],
+	"yellow": playerColors[4],
+	"orange": playerColors[5],
+	"teal":   playerColors[6],
+	"grey":   playerColors[7],
+}
+
+// DefaultCommands returns a registry with the built-in commands.
+func DefaultCommands() *CommandRegistry {
+	r := NewCommandRegistry()
+	r.Register(&Command{
+		Name: "help", Usage: "/help [command]", Help: "List commands or describe one.",
+		MaxArgs: 1,
+		Run: func(ctx *CommandContext) (string, error) {
+			level := ctx.Hub.permission(ctx.Client)
+			if len(ctx.Args) == 1 {
+				cmd, ok := r.Lookup(strings.TrimPrefix(ctx.Args[0], "/"))
+				if !ok || cmd.Level > level {
+					return "", fmt.Errorf("unknown command %s", ctx.Args[0])
+				}
+				return cmd.Usage + " - " + cmd.Help, nil
+			}
+			lines := []string{"Commands:"}
+			for _, cmd := range r.Available(level) {
+				lines = append(lines, fmt.Sprintf("%s - %s", cmd.Usage, cmd.Help))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+	r.Register(&Command{
+		Name: "who", Usage: "/who", Help: "List the players in this room.",
+		Run: func(ctx *CommandContext) (string, error) {
+			snap := ctx.Hub.State.Snapshot()
+			ids := make([]string, 0, len(snap))
+			for id := range snap {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			rtts := make(map[string]time.Duration, len(ctx.Hub.clients))
+			for client := range ctx.Hub.clients {
+				rtts[client.ID] = client.RTT()
+			}
+			owner := ctx.Hub.owner()
+			lines := []string{fmt.Sprintf("%d player(s) in room %s:", len(ids), ctx.Hub.Code)}
+			for _, id := range ids {
+				line := id
+				if name := snap[id].Name; name != "" {
+					line = name + " (" + id + ")"
+				}
+				if id == owner {
+					line += " [owner]"
+				}
+				if !snap[id].Expires.IsZero() {
+					line += " [reconnecting]"
+				} else if rtt := rtts[id]; rtt > 0 {
+					line += fmt.Sprintf(" %d ms", rtt.Milliseconds())
+				}
+				lines = append(lines, "  "+line)
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+	r.Register(&Command{
+		Name: "color", Usage: "/color <name> | /color <r> <g> <b>", Help: "Change your colour.",
+		MinArgs: 1, MaxArgs: 3,
+		Run: func(ctx *CommandContext) (string, error) {
+			c, err := parseColor(ctx.Args)
+			if err != nil {
+				return "", err
+			}
+			ctx.Hub.State.SetColor(ctx.Client.ID, c)
+			return fmt.Sprintf("colour set to (%d, %d, %d)", c.R, c.G, c.B), nil
+		},
+	})
+	r.Register(&Command{
+		Name: "respawn", Usage: "/respawn", Help: "Move to a random spawn point.",
+		Run: func(ctx *CommandContext) (string, error) {
+			x, y := ctx.Hub.randomSpawn()
+			ctx.Hub.State.Teleport(ctx.Client.ID, x, y)
+			return "respawned", nil
+		},
+	})
+	r.Register(&Command{
+		Name: "ping", Usage: "/ping", Help: "Check that the server is responding.",
+		Run: func(ctx *CommandContext) (string, error) {
+			reply := fmt.Sprintf("pong (room %s, tick %d)", ctx.Hub.Code, ctx.Hub.tick)
+			if rtt := ctx.Client.RTT(); rtt > 0 {
+				reply += fmt.Sprintf(", round trip %d ms", rtt.Milliseconds())
+			}
+			return reply, nil
+		},
+	})
+	r.Register(&Command{
+		Name: "kick", Usage: "/kick <player id>", Help: "Remove a player from the room.",
+		Level: PermOwner, MinArgs: 1, MaxArgs: 1,
+		Run: func(ctx *CommandContext) (string, error) {
+			target := ctx.Args[0]
+			if target == ctx.Client.ID {
+				return "", errors.New("you cannot kick yourself")
+			}
+			if !ctx.Hub.kick(target) {
+				return "", fmt.Errorf("no player %s in this room", target)
+			}
+			return "kicked " + target, nil
+		},
+	})
+	return r
+}
+
+// parseColor parses /color arguments: a palette name or three 0-255 values.
+func parseColor(args []string) (protocol.Color, error) {
+	if len(args) == 1 {
+		c, ok := colorNames[strings.ToLower(args[0])]
+		if !ok {
+			names := make([]string, 0, len(colorNames))
+			for name := range colorNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return protocol.Color{}, fmt.Errorf("unknown colour; pick one of %s or give r g b", strings.Join(names, ", "))
+		}
+		return c, nil
+	}
+	if len(args) != 3 {
+		return protocol.Color{}, errors.New("usage: /color <name> | /color <r> <g> <b>")
+	}
+	var rgb [3]uint8
+	for i, arg := range args {
+		v, err := strconv.ParseUint(arg, 10, 8)
+		if err != nil {
+			return protocol.Color{}, fmt.Errorf("%q is not a value from 0 to 255", arg)
+		}
+		rgb[i] = uint8(v)
+	}
+	return protocol.Color{R: rgb[0], G: rgb[1], B: rgb[2]}, nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOwnerIsLongestConnectedPlayer(t *testing.T) {
+	h, rooms := newTestHub(DefaultConfig())
+	first, _ := addTestClient(t, h, rooms, "p1")
+	second, _ := addTestClient(t, h, rooms, "p2")
+	third, _ := addTestClient(t, h, rooms, "p3")
+	start := time.Now()
+	for i, id := range []string{"p1", "p2", "p3"} {
+		h.State.Players[id].Joined = start.Add(time.Duration(i) * time.Second)
+	}
+
+	if got := h.owner(); got != "p1" {
+		t.Fatalf("owner = %q, want p1", got)
+	}
+	if _, err := h.Commands.Execute(h, second, "kick p3"); err == nil || !strings.Contains(err.Error(), "owner only") {
+		t.Errorf("non-owner kick: err = %v, want an owner-only error", err)
+	}
+
+	// The owner disconnects but may still resume.
+	h.detachClient(first)
+	h.State.Disconnect("p1", time.Now().Add(time.Minute))
+	if got := h.owner(); got != "p2" {
+		t.Fatalf("owner after p1 disconnected = %q, want p2", got)
+	}
+	if _, err := h.Commands.Execute(h, second, "kick p3"); err != nil {
+		t.Fatalf("owner kick: %v", err)
+	}
+	if h.clients[third] {
+		t.Error("kicked client is still in the room")
+	}
+
+	h.detachClient(second)
+	h.State.RemovePlayer("p2")
+	if got := h.owner(); got != "" {
+		t.Errorf("owner of a room without connected players = %q, want none", got)
+	}
+}
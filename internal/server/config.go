@@ -0,0 +1,474 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+	"ebiten-fullstack-template/internal/sim"
+)
+
+// Config holds the server settings. Load it with LoadConfig, or start from
+// DefaultConfig.
+type Config struct {
+	// Addr is the address the HTTP server listens on.
+	Addr string
+
+	// TickRate is the number of simulation ticks per second in every room.
+	TickRate int
+
+	// SessionGrace is how long a disconnected player is kept for its client
+	// to resume the session.
+	SessionGrace time.Duration
+
+	// DrainPeriod is how long Shutdown keeps serving after /readyz starts
+	// failing, so that load balancers stop sending new players first.
+	DrainPeriod time.Duration
+
+	// TLSCert and TLSKey are the paths of a PEM certificate and key. If set,
+	// the server speaks HTTPS and reloads them when they change.
+	TLSCert, TLSKey string
+
+	// RedirectAddr, if set with TLS, is an address for a plain HTTP listener
+	// redirecting everything to HTTPS, e.g. ":80".
+	RedirectAddr string
+
+	// AssetsDir, if set, serves the web client from this directory instead
+	// of the files embedded in the binary, e.g. "web" during development.
+	AssetsDir string
+
+	// Dev accepts WebSocket connections from any origin, e.g. a client
+	// served by a separate dev server. Never enable it in production.
+	Dev bool
+
+	// OriginPatterns lists the hosts, in path.Match syntax, whose pages may
+	// open WebSocket connections besides the server's own origin, e.g.
+	// "game.example.com" or "*.example.com". Ignored in Dev mode.
+	OriginPatterns []string
+
+	// WriteWait bounds the time to write one message to a client.
+	WriteWait time.Duration
+
+	// PingInterval is how often clients are pinged to measure their RTT and
+	// keep idle connections alive.
+	PingInterval time.Duration
+
+	// ReadTimeout is how long a client may stay silent, pongs included,
+	// before it is disconnected.
+	ReadTimeout time.Duration
+
+	// MaxMessageSize is the largest message accepted from a client, in bytes.
+	MaxMessageSize int64
+
+	// SendBufferSize is the number of outgoing messages queued per client
+	// before it is considered too slow.
+	SendBufferSize int
+
+	// SlowClientPolicy decides what happens to state snapshots for clients
+	// that fall behind. Clients whose queue fills up regardless are
+	// disconnected.
+	SlowClientPolicy SlowClientPolicy
+
+	// Spawn is the area new players start in.
+	Spawn Rect
+
+	// Colors are the colors new players are given at random.
+	Colors []protocol.Color
+
+	// Limits are the rate limits applied to clients.
+	Limits RateLimits
+
+	// LogLevel is the least severe level logged; LogFormat is "text" or
+	// "json".
+	LogLevel  slog.Level
+	LogFormat string
+}
+
+// Rect is an axis-aligned rectangle in world coordinates.
+type Rect struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// DefaultConfig returns the settings used for anything not configured.
+func DefaultConfig() Config {
+	return Config{
+		Addr:             ":8080",
+		TickRate:         DefaultTickRate,
+		SessionGrace:     DefaultSessionGrace,
+		DrainPeriod:      5 * time.Second,
+		WriteWait:        10 * time.Second,
+		PingInterval:     5 * time.Second,
+		ReadTimeout:      20 * time.Second,
+		MaxMessageSize:   4096,
+		SendBufferSize:   256,
+		SlowClientPolicy: Coalesce,
+		Spawn:            Rect{MinX: 100, MinY: 100, MaxX: 540, MaxY: 380},
+		Colors:           append([]protocol.Color(nil), playerColors...),
+		Limits:           DefaultRateLimits,
+		LogLevel:         slog.LevelInfo,
+		LogFormat:        "text",
+	}
+}
+
+// Validate reports the first setting that is out of range.
+func (c *Config) Validate() error {
+	switch {
+	case c.Addr == "":
+		return errors.New("addr must not be empty")
+	case c.TickRate < 1 || c.TickRate > sim.FrameRate:
+		return fmt.Errorf("tick-rate must be between 1 and %d", sim.FrameRate)
+	case (c.TLSCert == "") != (c.TLSKey == ""):
+		return errors.New("tls-cert and tls-key must be set together")
+	case c.RedirectAddr != "" && c.TLSCert == "":
+		return errors.New("redirect-addr requires tls-cert and tls-key")
+	case c.AssetsDir != "" && !isDir(c.AssetsDir):
+		return fmt.Errorf("assets-dir %s is not a directory", c.AssetsDir)
+	case c.SessionGrace < 0:
+		return errors.New("session-grace must not be negative")
+	case c.DrainPeriod < 0:
+		return errors.New("drain-period must not be negative")
+	case c.WriteWait <= 0:
+		return errors.New("write-wait must be positive")
+	case c.PingInterval <= 0:
+		return errors.New("ping-interval must be positive")
+	case c.ReadTimeout <= c.PingInterval:
+		return errors.New("read-timeout must be longer than ping-interval")
+	case c.MaxMessageSize < 512:
+		return errors.New("max-message-size must be at least 512 bytes")
+	case c.SendBufferSize < 1:
+		return errors.New("send-buffer must be at least 1")
+	case !c.SlowClientPolicy.valid():
+		return fmt.Errorf("slow-client must be %s, %s or %s", Coalesce, DropOldest, Disconnect)
+	case c.Spawn.MinX > c.Spawn.MaxX || c.Spawn.MinY > c.Spawn.MaxY ||
+		c.Spawn.MinX < 0 || c.Spawn.MinY < 0 ||
+		c.Spawn.MaxX > sim.WorldWidth || c.Spawn.MaxY > sim.WorldHeight:
+		return fmt.Errorf("spawn must lie within the %dx%d world", sim.WorldWidth, sim.WorldHeight)
+	case len(c.Colors) == 0:
+		return errors.New("colors must not be empty")
+	case c.LogFormat != "text" && c.LogFormat != "json":
+		return errors.New(`log-format must be "text" or "json"`)
+	case c.Limits.MessageRate < 0 || c.Limits.ConnectionRate < 0:
+		return errors.New("rates must not be negative")
+	case c.Limits.MessageRate > 0 && c.Limits.MessageBurst < 1,
+		c.Limits.ConnectionRate > 0 && c.Limits.ConnectionBurst < 1:
+		return errors.New("bursts must be at least 1 when the rate is set")
+	}
+	return nil
+}
+
+// setting is one configurable value. It is named name on the command line
+// and in config files, and SERVER_<NAME> in the environment.
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// settings lists everything LoadConfig can set.
+var settings = []setting{
+	{"addr", "listen address", func(c *Config, v string) error {
+		c.Addr = v
+		return nil
+	}},
+	{"tick-rate", "simulation ticks per second", func(c *Config, v string) error {
+		return parseInt(v, &c.TickRate)
+	}},
+	{"session-grace", "how long disconnected players are kept, e.g. 30s", func(c *Config, v string) error {
+		return parseDuration(v, &c.SessionGrace)
+	}},
+	{"drain-period", "how long to keep serving after /readyz fails on shutdown, e.g. 5s", func(c *Config, v string) error {
+		return parseDuration(v, &c.DrainPeriod)
+	}},
+	{"tls-cert", "TLS certificate file (PEM); enables HTTPS", func(c *Config, v string) error {
+		c.TLSCert = v
+		return nil
+	}},
+	{"tls-key", "TLS private key file (PEM)", func(c *Config, v string) error {
+		c.TLSKey = v
+		return nil
+	}},
+	{"redirect-addr", "address of an HTTP listener redirecting to HTTPS, e.g. :80", func(c *Config, v string) error {
+		c.RedirectAddr = v
+		return nil
+	}},
+	{"assets-dir", "serve the web client from this directory instead of the embedded files", func(c *Config, v string) error {
+		c.AssetsDir = v
+		return nil
+	}},
+	{"dev", "accept WebSocket connections from any origin (development only)", func(c *Config, v string) error {
+		return parseBool(v, &c.Dev)
+	}},
+	{"origins", "comma-separated extra origin hosts allowed to connect, e.g. *.example.com", func(c *Config, v string) error {
+		c.OriginPatterns = splitList(v)
+		return nil
+	}},
+	{"write-wait", "time allowed to write a message to a client", func(c *Config, v string) error {
+		return parseDuration(v, &c.WriteWait)
+	}},
+	{"ping-interval", "how often clients are pinged", func(c *Config, v string) error {
+		return parseDuration(v, &c.PingInterval)
+	}},
+	{"read-timeout", "disconnect clients silent for this long", func(c *Config, v string) error {
+		return parseDuration(v, &c.ReadTimeout)
+	}},
+	{"max-message-size", "largest message accepted from a client, in bytes", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxMessageSize = n
+		return err
+	}},
+	{"send-buffer", "messages queued per client before it is considered too slow", func(c *Config, v string) error {
+		return parseInt(v, &c.SendBufferSize)
+	}},
+	{"slow-client", "what to do with snapshots for slow clients: coalesce, drop-oldest or disconnect", func(c *Config, v string) error {
+		return parseSlowClientPolicy(v, &c.SlowClientPolicy)
+	}},
+	{"spawn", "spawn area as minX,minY,maxX,maxY", func(c *Config, v string) error {
+		return parseRect(v, &c.Spawn)
+	}},
+	{"colors", "comma-separated player colors, e.g. #e74c3c,#2ecc71", func(c *Config, v string) error {
+		colors, err := parseColors(v)
+		c.Colors = colors
+		return err
+	}},
+	{"message-rate", "messages per second a client may send; 0 disables the limit", func(c *Config, v string) error {
+		return parseFloat(v, &c.Limits.MessageRate)
+	}},
+	{"message-burst", "messages a client may send at once", func(c *Config, v string) error {
+		return parseFloat(v, &c.Limits.MessageBurst)
+	}},
+	{"connection-rate", "connections per second per IP; 0 disables the limit", func(c *Config, v string) error {
+		return parseFloat(v, &c.Limits.ConnectionRate)
+	}},
+	{"connection-burst", "connections per IP at once", func(c *Config, v string) error {
+		return parseFloat(v, &c.Limits.ConnectionBurst)
+	}},
+	{"log-level", "least severe level logged: debug, info, warn or error", func(c *Config, v string) error {
+		return parseLevel(v, &c.LogLevel)
+	}},
+	{"log-format", "log output format: text or json", func(c *Config, v string) error {
+		c.LogFormat = v
+		return nil
+	}},
+}
+
+// envName returns the environment variable for a setting.
+func (s setting) envName() string {
+	return "SERVER_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+// lookupSetting returns the setting with the given name.
+func lookupSetting(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, a config file, environment variables and command-line flags.
+// The config file is named by the -config flag or SERVER_CONFIG; files
+// ending in .json hold a JSON object, anything else "name = value" lines.
+// Both use the flag names as keys. args excludes the program name.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	type flagValue struct {
+		s     setting
+		value string
+	}
+	var flags []flagValue
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := fs.String("config", getenv("SERVER_CONFIG"), "config file (.json or name = value lines)")
+	defaults := DefaultConfig()
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.envName())
+		record := func(v string) error {
+			// Check the value now so that errors name the flag.
+			if err := s.set(&defaults, v); err != nil {
+				return err
+			}
+			flags = append(flags, flagValue{s, v})
+			return nil
+		}
+		if s.name == "dev" {
+			fs.BoolFunc(s.name, usage, record)
+		} else {
+			fs.Func(s.name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := DefaultConfig()
+	if *path != "" {
+		if err := loadConfigFile(*path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		if v := getenv(s.envName()); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.envName(), err)
+			}
+		}
+	}
+	for _, f := range flags {
+		_ = f.s.set(&cfg, f.value) // checked while parsing
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadConfigFile applies the settings in the file at path to cfg.
+func loadConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values, err := readConfigValues(f, strings.EqualFold(filepath.Ext(path), ".json"))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, kv := range values {
+		s, ok := lookupSetting(kv[0])
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, kv[0])
+		}
+		if err := s.set(cfg, kv[1]); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, kv[0], err)
+		}
+	}
+	return nil
+}
+
+// readConfigValues returns the name/value pairs of a config file in order.
+// JSON arrays become comma-separated lists.
+func readConfigValues(r io.Reader, isJSON bool) ([][2]string, error) {
+	var values [][2]string
+	if isJSON {
+		var obj map[string]json.RawMessage
+		if err := json.NewDecoder(r).Decode(&obj); err != nil {
+			return nil, err
+		}
+		for name, raw := range obj {
+			var v string
+			switch {
+			case json.Unmarshal(raw, &v) == nil:
+			case len(raw) > 0 && raw[0] == '[':
+				var list []string
+				if err := json.Unmarshal(raw, &list); err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				v = strings.Join(list, ",")
+			default:
+				v = string(raw) // number or boolean
+			}
+			values = append(values, [2]string{name, v})
+		}
+		return values, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		name, v, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected name = value", line)
+		}
+		v = strings.TrimSpace(v)
+		if unquoted, err := strconv.Unquote(v); err == nil {
+			v = unquoted
+		}
+		values = append(values, [2]string{strings.TrimSpace(name), v})
+	}
+	return values, scanner.Err()
+}
+
+func parseInt(v string, dst *int) error {
+	n, err := strconv.Atoi(v)
+	*dst = n
+	return err
+}
+
+func parseFloat(v string, dst *float64) error {
+	f, err := strconv.ParseFloat(v, 64)
+	*dst = f
+	return err
+}
+
+func parseBool(v string, dst *bool) error {
+	b, err := strconv.ParseBool(v)
+	*dst = b
+	return err
+}
+
+func parseDuration(v string, dst *time.Duration) error {
+	d, err := time.ParseDuration(v)
+	*dst = d
+	return err
+}
+
+// isDir reports whether name is an existing directory.
+func isDir(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.IsDir()
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRect parses "minX,minY,maxX,maxY".
+func parseRect(v string, dst *Rect) error {
+	parts := splitList(v)
+	if len(parts) != 4 {
+		return errors.New("expected minX,minY,maxX,maxY")
+	}
+	var f [4]float64
+	for i, p := range parts {
+		if err := parseFloat(p, &f[i]); err != nil {
+			return err
+		}
+	}
+	*dst = Rect{MinX: f[0], MinY: f[1], MaxX: f[2], MaxY: f[3]}
+	return nil
+}
+
+// parseColors parses a comma-separated list of #rrggbb colors.
+func parseColors(v string) ([]protocol.Color, error) {
+	var colors []protocol.Color
+	for _, item := range splitList(v) {
+		hex := strings.TrimPrefix(item, "#")
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 6 || err != nil {
+			return nil, fmt.Errorf("%q is not a #rrggbb color", item)
+		}
+		colors = append(colors, protocol.Color{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n)})
+	}
+	return colors, nil
+}
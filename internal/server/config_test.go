@@ -0,0 +1,124 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// writeConfig writes a config file named name and returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// env returns a getenv for LoadConfig backed by vars.
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, "server.conf", `
+# comment
+addr = :9000
+tick-rate = 10
+session-grace = 1m
+slow-client = "drop-oldest"
+`)
+	cfg, err := LoadConfig(
+		[]string{"-config", path, "-tick-rate", "30"},
+		env(map[string]string{
+			"SERVER_TICK_RATE":     "20",
+			"SERVER_SESSION_GRACE": "45s",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TickRate != 30 {
+		t.Errorf("tick rate %d, want 30 from the flag over env and file", cfg.TickRate)
+	}
+	if cfg.SessionGrace != 45*time.Second {
+		t.Errorf("session grace %v, want 45s from env over the file", cfg.SessionGrace)
+	}
+	if cfg.Addr != ":9000" {
+		t.Errorf("addr %q, want :9000 from the file", cfg.Addr)
+	}
+	if cfg.SlowClientPolicy != DropOldest {
+		t.Errorf("slow client policy %q, want %q from the file", cfg.SlowClientPolicy, DropOldest)
+	}
+	if want := DefaultConfig().WriteWait; cfg.WriteWait != want {
+		t.Errorf("write wait %v, want the default %v", cfg.WriteWait, want)
+	}
+}
+
+func TestLoadConfigJSONFromEnv(t *testing.T) {
+	path := writeConfig(t, "server.json", `{
+		"tick-rate": 25,
+		"dev": true,
+		"colors": ["#ff0000", "#00ff00"],
+		"origins": ["a.example.com", "*.b.example.com"]
+	}`)
+	cfg, err := LoadConfig(nil, env(map[string]string{"SERVER_CONFIG": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TickRate != 25 || !cfg.Dev {
+		t.Errorf("tick rate %d, dev %v; want 25, true", cfg.TickRate, cfg.Dev)
+	}
+	if want := []protocol.Color{{R: 255}, {G: 255}}; len(cfg.Colors) != 2 || cfg.Colors[0] != want[0] || cfg.Colors[1] != want[1] {
+		t.Errorf("colors %v, want %v", cfg.Colors, want)
+	}
+	if len(cfg.OriginPatterns) != 2 || cfg.OriginPatterns[1] != "*.b.example.com" {
+		t.Errorf("origins %v", cfg.OriginPatterns)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		wantErr string
+	}{
+		{name: "bad flag", args: []string{"-tick-rate", "fast"}, wantErr: "tick-rate"},
+		{name: "unknown flag", args: []string{"-nope", "1"}, wantErr: "nope"},
+		{name: "bad env", env: map[string]string{"SERVER_WRITE_WAIT": "soon"}, wantErr: "SERVER_WRITE_WAIT"},
+		{name: "bad file value", file: "read-timeout = forever\n", wantErr: "read-timeout"},
+		{name: "unknown file key", file: "tick_rate = 30\n", wantErr: `unknown setting "tick_rate"`},
+		{name: "unknown json key", file: `{"bogus": 1}`, wantErr: `unknown setting "bogus"`},
+		{name: "malformed line", file: "tick-rate 30\n", wantErr: "line 1"},
+		{name: "out of range", args: []string{"-tick-rate", "0"}, wantErr: "tick-rate must be"},
+		{name: "invalid combination", env: map[string]string{"SERVER_TLS_CERT": "cert.pem"}, wantErr: "tls-key"},
+		{name: "bad policy", args: []string{"-slow-client", "ignore"}, wantErr: "unknown policy"},
+		{name: "missing file", args: []string{"-config", "/does/not/exist.conf"}, wantErr: "exist.conf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				name := "server.conf"
+				if strings.HasPrefix(tt.file, "{") {
+					name = "server.json"
+				}
+				args = append([]string{"-config", writeConfig(t, name, tt.file)}, args...)
+			}
+			_, err := LoadConfig(args, env(tt.env))
+			if err == nil {
+				t.Fatalf("no error, want one mentioning %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q does not mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// helloTimeout bounds how long a new connection may take to say hello.
+const helloTimeout = 5 * time.Second
+
+// errIncompatible is returned by handshake when the client speaks another
+// protocol version.
+var errIncompatible = errors.New("incompatible protocol version")
+
+// handshake reads the client's hello, rejects incompatible clients and
+// answers with the server's hello. It returns the client's hello, including
+// its session token, with the features narrowed to those both sides
+// support. On error the connection has already been closed.
+func handshake(conn *websocket.Conn, codec protocol.Codec, remoteAddr string) (protocol.HelloData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helloTimeout)
+	defer cancel()
+
+	_, message, err := conn.Read(ctx)
+	if err != nil {
+		_ = conn.Close(websocket.StatusPolicyViolation, "hello timeout")
+		return protocol.HelloData{}, err
+	}
+	env, err := codec.Unmarshal(message)
+	if err == nil && env.Type != protocol.MsgHello {
+		err = fmt.Errorf("expected %s, got %s", protocol.MsgHello, env.Type)
+	}
+	var hello protocol.HelloData
+	if err == nil {
+		err = env.Decode(&hello)
+	}
+	if err != nil {
+		_ = conn.Close(websocket.StatusProtocolError, "expected hello")
+		return protocol.HelloData{}, err
+	}
+
+	if hello.Version != protocol.Version {
+		reason := fmt.Sprintf("protocol version %d is not supported (server speaks %d): please reload",
+			hello.Version, protocol.Version)
+		_ = conn.Close(websocket.StatusCode(protocol.CloseIncompatible), reason)
+		return protocol.HelloData{}, errIncompatible
+	}
+	if hello.Build != protocol.BuildHash {
+		slog.Info("client runs a different build", "remote", remoteAddr, "client_build", hello.Build, "server_build", protocol.BuildHash)
+	}
+
+	reply := protocol.HelloData{Version: protocol.Version, Build: protocol.BuildHash}
+	for _, f := range protocol.Features {
+		if hello.Has(f) {
+			reply.Features = append(reply.Features, f)
+		}
+	}
+	msg, err := codec.Marshal(protocol.MsgHello, reply)
+	if err != nil {
+		_ = conn.Close(websocket.StatusInternalError, "")
+		return protocol.HelloData{}, err
+	}
+	if err := conn.Write(ctx, frameType(codec), msg); err != nil {
+		_ = conn.Close(websocket.StatusInternalError, "")
+		return protocol.HelloData{}, err
+	}
+	hello.Features = reply.Features
+	return hello, nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// InfoData is the body of GET /info.
+type InfoData struct {
+	Version int    `json:"version"` // protocol version
+	Build   string `json:"build"`
+	Started string `json:"started"` // RFC 3339
+	Uptime  string `json:"uptime"`
+	Seconds int64  `json:"uptime_seconds"`
+	RoomStats
+}
+
+// handleHealthz reports that the process is up and serving HTTP.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the server wants new players; it fails as
+// soon as Shutdown begins, while the drain period still serves them.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if s.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("draining\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ready\n"))
+}
+
+// handleInfo returns the build, uptime and room counts as JSON.
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	uptime := time.Since(s.started)
+	info := InfoData{
+		Version:   protocol.Version,
+		Build:     protocol.BuildHash,
+		Started:   s.started.UTC().Format(time.RFC3339),
+		Uptime:    uptime.Round(time.Second).String(),
+		Seconds:   int64(uptime.Seconds()),
+		RoomStats: s.Rooms.Stats(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		slog.Warn("write info failed", "err", err)
+	}
+}
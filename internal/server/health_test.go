@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// probe returns the status of a GET to handler.
+func probe(handler http.HandlerFunc, target string) int {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec.Code
+}
+
+func TestShutdownDrains(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DrainPeriod = 300 * time.Millisecond
+	s, url := newTestServer(t, cfg)
+
+	if code := probe(s.handleReadyz, "/readyz"); code != http.StatusOK {
+		t.Fatalf("readyz = %d before shutdown, want 200", code)
+	}
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	deadline := time.Now().Add(time.Second)
+	for probe(s.handleReadyz, "/readyz") != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("readyz still ready after Shutdown began")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Players routed here before the load balancer noticed still get in.
+	dialRoom(t, url, "drain", "")
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < cfg.DrainPeriod {
+		t.Errorf("Shutdown returned after %v, before the %v drain period", elapsed, cfg.DrainPeriod)
+	}
+	if code := probe(s.handleWebSocket, "/ws"); code != http.StatusServiceUnavailable {
+		t.Errorf("websocket upgrade = %d after shutdown, want 503", code)
+	}
+}
+
+func TestShutdownDrainEndsWithContext(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DrainPeriod = time.Hour
+	s, _ := newTestServer(t, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown kept draining after its context ended")
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// ping sends a heartbeat carrying the last measured RTT. It is called from
+// WritePump.
+func (c *Client) ping() error {
+	seq := c.pingSeq.Add(1)
+	c.pingSent.Store(time.Now().UnixNano())
+	var ms int64
+	if rtt := c.RTT(); rtt > 0 {
+		ms = max(rtt.Milliseconds(), 1) // zero means unknown
+	}
+	return c.writeNow(protocol.MsgPing, protocol.PingData{Seq: seq, RTT: uint32(ms)})
+}
+
+// handleTimeSync answers a clock sample with the server's clock. The reply
+// bypasses the send queue so that queueing does not skew the sample.
+func (c *Client) handleTimeSync(env protocol.Envelope) {
+	var req protocol.TimeSyncData
+	if err := env.Decode(&req); err != nil {
+		c.logBadMessage("malformed message", "type", env.Type, "err", err)
+		return
+	}
+	req.Server = time.Now().UnixMilli()
+	if err := c.writeNow(protocol.MsgTimeSync, req); err != nil {
+		c.logger().Debug("write time sync failed", "err", err)
+	}
+}
+
+// writeNow writes a message directly to the connection, ahead of anything
+// queued on send. The connection allows concurrent writers.
+func (c *Client) writeNow(msgType protocol.MessageType, data interface{}) error {
+	msg, err := c.codec.Marshal(msgType, data)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.writeWait)
+	defer cancel()
+	if err := c.conn.Write(ctx, c.frameType(), msg); err != nil {
+		return err
+	}
+	c.metrics.messageOut(msgType, len(msg))
+	return nil
+}
+
+// handlePong measures the RTT from a MsgPong answering the latest ping.
+// Answers to older pings are ignored.
+func (c *Client) handlePong(env protocol.Envelope) {
+	var pong protocol.PongData
+	if err := env.Decode(&pong); err != nil {
+		c.logBadMessage("malformed message", "type", env.Type, "err", err)
+		return
+	}
+	if pong.Seq != c.pingSeq.Load() {
+		return
+	}
+	rtt := time.Since(time.Unix(0, c.pingSent.Load()))
+	c.rtt.Store(int64(rtt))
+	c.metrics.RTT.ObserveDuration(rtt)
+}
+
+// RTT returns the round-trip time measured with the latest answered ping, or
+// zero if none has been answered yet.
+func (c *Client) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
@@ -1,14 +1,23 @@
 package server
 
 import (
-	"log"
+	"log/slog"
 	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
 
 	"ebiten-fullstack-template/internal/protocol"
+	"ebiten-fullstack-template/internal/sim"
 )
 
-// Predefined player colors for the demo.
+// DefaultTickRate is the number of simulation ticks per second used when a
+// non-positive rate is configured.
+const DefaultTickRate = 20
+
+// Predefined player colors for the demo; /color knows them by name.
 var playerColors = []protocol.Color{
 	{R: 231, G: 76, B: 60},   // red
 	{R: 46, G: 204, B: 113},  // green
@@ -20,16 +29,26 @@ var playerColors = []protocol.Color{
 	{R: 236, G: 240, B: 241}, // light grey
 }
 
-func randomColor() protocol.Color {
-	return playerColors[rand.Intn(len(playerColors))]
-}
-
 // ----- Game State -----
 
 // PlayerState holds a single player's position and color.
 type PlayerState struct {
+	Name  string
 	X, Y  float64
 	Color protocol.Color
+
+	// LastSeq is the sequence number of the last input applied.
+	LastSeq uint32
+
+	// Token resumes the player's session after a reconnect.
+	Token string
+
+	// Expires is when a disconnected player is removed; zero while the
+	// player is connected.
+	Expires time.Time
+
+	// Joined is when the player entered the room; resuming keeps it.
+	Joined time.Time
 }
 
 // GameState tracks all connected players and their positions.
@@ -45,11 +64,11 @@ func NewGameState() *GameState {
 	}
 }
 
-// AddPlayer registers a new player.
-func (gs *GameState) AddPlayer(id string, x, y float64, c protocol.Color) {
+// AddPlayer registers a new player whose session can be resumed with token.
+func (gs *GameState) AddPlayer(id string, x, y float64, c protocol.Color, token string) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Players[id] = &PlayerState{X: x, Y: y, Color: c}
+	gs.Players[id] = &PlayerState{X: x, Y: y, Color: c, Token: token, Joined: time.Now()}
 }
 
 // RemovePlayer removes a player from the game state.
@@ -59,14 +78,46 @@ func (gs *GameState) RemovePlayer(id string) {
 	delete(gs.Players, id)
 }
 
-// UpdatePosition updates a player's position.
-func (gs *GameState) UpdatePosition(id string, x, y float64) {
+// ApplyInput simulates one frame of input for a player. Inputs that are not
+// newer than the last applied one are ignored.
+func (gs *GameState) ApplyInput(id string, in protocol.InputData) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	p, ok := gs.Players[id]
+	if !ok || in.Seq <= p.LastSeq {
+		return
+	}
+	p.X, p.Y = sim.Step(p.X, p.Y, in)
+	p.LastSeq = in.Seq
+}
+
+// SetColor changes a player's color.
+func (gs *GameState) SetColor(id string, c protocol.Color) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if p, ok := gs.Players[id]; ok {
+		p.Color = c
+	}
+}
+
+// Teleport moves a player to x, y.
+func (gs *GameState) Teleport(id string, x, y float64) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	if p, ok := gs.Players[id]; ok {
-		p.X = x
-		p.Y = y
+		p.X, p.Y = x, y
+	}
+}
+
+// Player returns a copy of one player's state.
+func (gs *GameState) Player(id string) (PlayerState, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	p, ok := gs.Players[id]
+	if !ok {
+		return PlayerState{}, false
 	}
+	return *p, true
 }
 
 // Snapshot returns a copy of all current player states.
@@ -82,13 +133,58 @@ func (gs *GameState) Snapshot() map[string]PlayerState {
 
 // ----- Hub -----
 
-// Hub maintains the set of active clients and broadcasts messages to them.
+const (
+	// historySize is the number of past snapshots kept as delta bases.
+	historySize = 64
+
+	// keyframeInterval is how often every client gets a full snapshot even
+	// if it keeps acknowledging deltas.
+	keyframeInterval = 5 * time.Second
+)
+
+// kickBan is how long the address of a kicked player may not enter the
+// room again.
+const kickBan = 5 * time.Minute
+
+// maxQueuedInputs bounds the inputs buffered per player between ticks.
+const maxQueuedInputs = 2 * sim.FrameRate
+
+// clientInput is a frame of input queued by a ReadPump until the next tick.
+type clientInput struct {
+	client *Client
+	input  protocol.InputData
+}
+
+// clientMessage is a message from a client that must be handled on the hub
+// goroutine, e.g. because it needs to see the whole room.
+type clientMessage struct {
+	client *Client
+	env    protocol.Envelope
+}
+
+// inputQueue buffers a player's inputs. Each tick grants credit for as many
+// frames as a client produces in that time, so a client flooding inputs
+// cannot move faster than sim.FrameRate allows.
+type inputQueue struct {
+	inputs []protocol.InputData
+	credit float64
+}
+
+// Hub maintains the set of active clients in one room and broadcasts
+// messages to them.
 type Hub struct {
+	// Code is the room code the hub serves.
+	Code string
+
+	// members counts clients that joined or are about to join the room; the
+	// RoomManager reads it from other goroutines.
+	members atomic.Int32
+
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Inbound messages from clients to broadcast.
-	broadcast chan []byte
+	// Messages to broadcast to all clients.
+	broadcast chan outbound
 
 	// Register requests from clients.
 	register chan *Client
@@ -96,127 +192,530 @@ type Hub struct {
 	// Unregister requests from clients.
 	unregister chan *Client
 
+	// Inputs queued by clients, applied to State on the next tick.
+	inputs chan clientInput
+
+	// Other messages from clients, handled by handleMessage.
+	messages chan clientMessage
+
+	// Queued inputs per player ID. Owned by the Run goroutine.
+	pending map[string]*inputQueue
+
 	// Stop signals the hub to shut down and close all clients.
 	stop chan struct{}
 
 	// Game state tracking all players.
 	State *GameState
+
+	// TickRate is the number of simulation ticks per second.
+	TickRate int
+
+	// SessionGrace is how long a disconnected player stays in the game
+	// waiting for its client to resume the session. Zero removes players
+	// immediately.
+	SessionGrace time.Duration
+
+	// spawn is the area new players start in.
+	spawn Rect
+
+	// colors are the colors new players are given.
+	colors []protocol.Color
+
+	// log carries the room code.
+	log *slog.Logger
+
+	// metrics records message counts and tick timings.
+	metrics *Metrics
+
+	// Commands are the slash commands players can run; nil disables them.
+	Commands *CommandRegistry
+
+	// slowPolicy decides what happens to snapshots for clients that fall
+	// behind.
+	slowPolicy SlowClientPolicy
+
+	// evicted lists players dropped for being too slow whose leave has not
+	// been broadcast yet. Owned by the Run goroutine.
+	evicted []string
+
+	// bans maps the addresses of kicked players to when they may enter the
+	// room again. Clients check it from other goroutines before entering.
+	bansMu sync.Mutex
+	bans   map[string]time.Time
+
+	// tick counts completed simulation ticks. Owned by the Run goroutine.
+	tick uint32
+
+	// chatHistory holds the most recent chat lines, oldest first. Owned by
+	// the Run goroutine.
+	chatHistory []protocol.ChatData
+
+	// history holds recent snapshots indexed by tick % historySize, used as
+	// bases for delta snapshots. Owned by the Run goroutine.
+	history [historySize]tickSnapshot
 }
 
-// NewHub creates a new Hub.
-func NewHub() *Hub {
+// outbound is a typed message waiting to be encoded for each client's codec.
+type outbound struct {
+	msgType protocol.MessageType
+	data    interface{}
+}
+
+// tickSnapshot is the player set broadcast at one tick.
+type tickSnapshot struct {
+	tick    uint32
+	players map[string]protocol.PlayerInfo
+}
+
+// NewHub creates a new Hub with the tick rate, session grace, spawn area,
+// colors, buffer sizes and slow client policy from cfg, recording into
+// metrics.
+func NewHub(cfg Config, metrics *Metrics) *Hub {
+	tickRate := cfg.TickRate
+	if tickRate <= 0 {
+		tickRate = DefaultTickRate
+	}
+	colors := cfg.Colors
+	if len(colors) == 0 {
+		colors = playerColors
+	}
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan outbound),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		inputs:     make(chan clientInput, cfg.SendBufferSize),
+		messages:   make(chan clientMessage, cfg.SendBufferSize),
+		pending:    make(map[string]*inputQueue),
 		stop:       make(chan struct{}),
 		clients:    make(map[*Client]bool),
 		State:      NewGameState(),
+		TickRate:   tickRate,
+		spawn:      cfg.Spawn,
+		colors:     colors,
+		metrics:    metrics,
+		log:        slog.Default(),
+		slowPolicy: cfg.SlowClientPolicy,
+
+		SessionGrace: cfg.SessionGrace,
 	}
 }
 
-// Stop shuts down the hub: closes all client send channels and exits the Run loop.
+// randomColor picks a color for a new player.
+func (h *Hub) randomColor() protocol.Color {
+	return h.colors[rand.Intn(len(h.colors))]
+}
+
+// randomSpawn returns a random position in the spawn area.
+func (h *Hub) randomSpawn() (x, y float64) {
+	return h.spawn.MinX + rand.Float64()*(h.spawn.MaxX-h.spawn.MinX),
+		h.spawn.MinY + rand.Float64()*(h.spawn.MaxY-h.spawn.MinY)
+}
+
+// Stop shuts down the hub: closes all client send queues and exits the Run loop.
 func (h *Hub) Stop() {
 	close(h.stop)
 }
 
 // Run starts the hub's main event loop. It should be called in its own goroutine.
+// The loop owns the simulation clock: queued inputs are applied and a single
+// state snapshot is broadcast once per tick.
 func (h *Hub) Run() {
+	ticker := time.NewTicker(time.Second / time.Duration(h.TickRate))
+	defer ticker.Stop()
+
 	for {
+		h.announceEvictions()
+
 		select {
 		case <-h.stop:
 			for client := range h.clients {
-				close(client.send)
-				delete(h.clients, client)
-				h.State.RemovePlayer(client.ID)
+				h.dropClient(client)
 			}
 			return
 		case client := <-h.register:
 			h.clients[client] = true
-
-			// Assign a random color and a randomized starting position.
-			c := randomColor()
-			startX := 100.0 + rand.Float64()*440.0 // [100, 540]
-			startY := 100.0 + rand.Float64()*280.0 // [100, 380]
-			h.State.AddPlayer(client.ID, startX, startY, c)
-
-			// Send welcome to the new client (their ID and color).
-			if msg, err := protocol.Marshal(protocol.MsgWelcome, protocol.WelcomeData{
-				ID: client.ID, Color: c,
-			}); err == nil {
-				client.send <- msg
+			if !client.resumed || !h.resume(client) {
+				h.join(client)
 			}
 
-			// Send current game state so the new client sees existing players.
-			client.send <- h.buildStateMessage()
-
-			// Broadcast join to all clients.
-			if msg, err := protocol.Marshal(protocol.MsgJoin, protocol.JoinData{
-				ID: client.ID, X: startX, Y: startY, Color: c,
-			}); err == nil {
-				h.broadcastBytes(msg)
-			}
-
-			log.Printf("player joined: %s (%d total)", client.ID, len(h.clients))
-
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.detachClient(client)
+
+				if h.SessionGrace > 0 {
+					// Keep the player until the client resumes or the
+					// grace period ends; others see no leave meanwhile.
+					h.State.Disconnect(client.ID, time.Now().Add(h.SessionGrace))
+					client.logger().Info("player disconnected", "clients", len(h.clients))
+					continue
+				}
 				h.State.RemovePlayer(client.ID)
 
 				// Broadcast leave to remaining clients.
-				if msg, err := protocol.Marshal(protocol.MsgLeave, protocol.LeaveData{
-					ID: client.ID,
-				}); err == nil {
-					h.broadcastBytes(msg)
-				}
+				h.broadcastMessage(protocol.MsgLeave, protocol.LeaveData{ID: client.ID})
 
-				log.Printf("player left: %s (%d total)", client.ID, len(h.clients))
+				client.logger().Info("player left", "clients", len(h.clients))
 			}
 
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-					h.State.RemovePlayer(client.ID)
-				}
+		case in := <-h.inputs:
+			h.queueInput(in)
+
+		case msg := <-h.messages:
+			if h.clients[msg.client] {
+				h.handleMessage(msg.client, msg.env)
 			}
+
+		case scheduled := <-ticker.C:
+			start := time.Now()
+			h.metrics.HubLoopLatency.ObserveDuration(start.Sub(scheduled))
+			h.step()
+			h.metrics.TickDuration.ObserveDuration(time.Since(start))
+
+		case out := <-h.broadcast:
+			h.broadcastMessage(out.msgType, out.data)
+		}
+	}
+}
+
+// queueInput buffers an input until the next tick. MUST be called only from
+// Run.
+func (h *Hub) queueInput(in clientInput) {
+	// Drop inputs from connections that were replaced or removed.
+	if !h.clients[in.client] {
+		return
+	}
+	q, ok := h.pending[in.client.ID]
+	if !ok {
+		q = &inputQueue{}
+		h.pending[in.client.ID] = q
+	}
+	if len(q.inputs) < maxQueuedInputs {
+		q.inputs = append(q.inputs, in.input)
+	}
+}
+
+// join adds a new player for client and announces it. MUST be called only
+// from Run.
+func (h *Hub) join(client *Client) {
+	// Assign a random color and a randomized starting position.
+	c := h.randomColor()
+	startX, startY := h.randomSpawn()
+	token := newSessionToken()
+	h.State.AddPlayer(client.ID, startX, startY, c, token)
+
+	// Apply the name picked in the lobby if nobody in the room has it.
+	name := ""
+	if client.name != "" {
+		if err := h.State.SetName(client.ID, client.name); err != nil {
+			h.sendMessage(client, protocol.MsgNameResult, protocol.NameResultData{Error: err.Error()})
+		} else {
+			name = client.name
+		}
+	}
+
+	// Send welcome to the new client (their ID, color and session token).
+	h.welcome(client, c, token)
+
+	// Broadcast join to all clients.
+	h.broadcastMessage(protocol.MsgJoin, protocol.JoinData{
+		ID: client.ID, Name: name, X: startX, Y: startY, Color: c,
+	})
+
+	client.logger().Info("player joined", "clients", len(h.clients))
+}
+
+// resume hands an existing player over to a reconnected client. It reports
+// false if the player is gone, in which case the client joins afresh. MUST
+// be called only from Run.
+func (h *Hub) resume(client *Client) bool {
+	// The previous connection may not have been noticed dead yet.
+	for old := range h.clients {
+		if old != client && old.ID == client.ID {
+			h.detachClient(old)
+		}
+	}
+
+	ps, ok := h.State.Reconnect(client.ID)
+	if !ok {
+		return false
+	}
+	h.welcome(client, ps.Color, ps.Token)
+
+	client.logger().Info("player resumed", "clients", len(h.clients))
+	return true
+}
+
+// welcome sends the welcome and the current game state to a client. MUST be
+// called only from Run.
+func (h *Hub) welcome(client *Client, c protocol.Color, token string) {
+	if msg, err := client.codec.Marshal(protocol.MsgWelcome, protocol.WelcomeData{
+		ID: client.ID, Color: c, Token: token,
+	}); err == nil {
+		client.send.push(outgoing{protocol.MsgWelcome, msg})
+		h.metrics.messageOut(protocol.MsgWelcome, len(msg))
+	}
+
+	// Send current game state so the new client sees existing players.
+	// It has no tick, so the client cannot use it as a delta base.
+	if msg, err := client.codec.Marshal(protocol.MsgState, protocol.StateData{
+		Players: protocol.PlayerList(h.snapshotPlayers()),
+		Time:    time.Now().UnixMilli(),
+	}); err == nil {
+		client.send.push(outgoing{protocol.MsgState, msg})
+		h.metrics.messageOut(protocol.MsgState, len(msg))
+	}
+
+	// Let late joiners catch up on the conversation.
+	if len(h.chatHistory) > 0 {
+		if msg, err := client.codec.Marshal(protocol.MsgChatHistory, protocol.ChatHistoryData{
+			Messages: h.chatHistory,
+		}); err == nil {
+			client.send.push(outgoing{protocol.MsgChatHistory, msg})
+			h.metrics.messageOut(protocol.MsgChatHistory, len(msg))
+		}
+	}
+}
+
+// handleMessage processes a non-input message from a client in the room.
+// MUST be called only from Run.
+func (h *Hub) handleMessage(client *Client, env protocol.Envelope) {
+	switch env.Type {
+	case protocol.MsgSetName:
+		var req protocol.SetNameData
+		if err := env.Decode(&req); err != nil {
+			client.logBadMessage("malformed message", "type", env.Type, "err", err)
+			return
+		}
+		name, err := normalizeName(req.Name)
+		if err == nil {
+			err = h.State.SetName(client.ID, name)
+		}
+		if err != nil {
+			h.sendMessage(client, protocol.MsgNameResult, protocol.NameResultData{Error: err.Error()})
+			return
+		}
+		// Other clients pick the name up from the next state snapshot.
+		h.sendMessage(client, protocol.MsgNameResult, protocol.NameResultData{OK: true, Name: name})
+		client.logger().Info("player renamed", "name", name)
+
+	case protocol.MsgChat:
+		h.handleChat(client, env)
+
+	case protocol.MsgCommand:
+		h.runCommand(client, env)
+	}
+}
+
+// owner returns the player ID of the room owner: the connected player that
+// joined the room first. A player who disconnects loses ownership until it
+// resumes. It returns "" if nobody is connected. MUST be called only from
+// Run.
+func (h *Hub) owner() string {
+	var owner string
+	var joined time.Time
+	for client := range h.clients {
+		ps, ok := h.State.Player(client.ID)
+		if !ok {
+			continue
+		}
+		if owner == "" || ps.Joined.Before(joined) || (ps.Joined.Equal(joined) && client.ID < owner) {
+			owner, joined = client.ID, ps.Joined
+		}
+	}
+	return owner
+}
+
+// kick removes the player with id from the room, closing its connection,
+// and keeps its address out of the room for kickBan. It reports false if
+// there is no such player. MUST be called only from Run.
+func (h *Hub) kick(id string) bool {
+	if _, ok := h.State.Player(id); !ok {
+		return false
+	}
+	for client := range h.clients {
+		if client.ID == id {
+			h.ban(client.remote, time.Now())
+			client.closeWith(websocket.StatusCode(protocol.CloseKicked), "kicked by the room owner")
+			h.dropClient(client)
 		}
 	}
+	h.State.RemovePlayer(id)
+	h.broadcastMessage(protocol.MsgLeave, protocol.LeaveData{ID: id})
+	h.log.Info("player kicked", "player", id)
+	return true
 }
 
-// Broadcast sends a raw message to all connected clients via the event loop.
-func (h *Hub) Broadcast(msg []byte) {
-	h.broadcast <- msg
+// ban keeps clients from remote out of the room for kickBan. It is safe for
+// concurrent use.
+func (h *Hub) ban(remote string, now time.Time) {
+	if remote == "" {
+		return
+	}
+	h.bansMu.Lock()
+	defer h.bansMu.Unlock()
+	if h.bans == nil {
+		h.bans = make(map[string]time.Time)
+	}
+	for addr, until := range h.bans {
+		if !now.Before(until) {
+			delete(h.bans, addr)
+		}
+	}
+	h.bans[remote] = now.Add(kickBan)
 }
 
-// broadcastBytes sends a raw message directly to every client's send channel.
+// banned reports whether clients from remote may not enter the room at time
+// now. It is safe for concurrent use.
+func (h *Hub) banned(remote string, now time.Time) bool {
+	h.bansMu.Lock()
+	defer h.bansMu.Unlock()
+	until, ok := h.bans[remote]
+	return ok && now.Before(until)
+}
+
+// Broadcast sends a message to all connected clients via the event loop.
+func (h *Hub) Broadcast(msgType protocol.MessageType, data interface{}) {
+	h.broadcast <- outbound{msgType: msgType, data: data}
+}
+
+// broadcastMessage encodes a message once per codec in use and sends it
+// directly to every client's send queue.
 // MUST be called only from the Hub.Run goroutine (which owns the clients map).
-func (h *Hub) broadcastBytes(msg []byte) {
+func (h *Hub) broadcastMessage(msgType protocol.MessageType, data interface{}) {
+	encoded := make(map[protocol.Codec][]byte, 2)
 	for client := range h.clients {
-		select {
-		case client.send <- msg:
-		default:
-			close(client.send)
-			delete(h.clients, client)
-			h.State.RemovePlayer(client.ID)
+		msg, ok := encoded[client.codec]
+		if !ok {
+			var err error
+			if msg, err = client.codec.Marshal(msgType, data); err != nil {
+				h.log.Error("marshal failed", "type", msgType, "err", err)
+				return
+			}
+			encoded[client.codec] = msg
+		}
+		h.sendTo(client, msgType, msg)
+	}
+}
+
+// sendMessage encodes a message for one client and queues it. MUST be called
+// only from the Hub.Run goroutine.
+func (h *Hub) sendMessage(client *Client, msgType protocol.MessageType, data interface{}) {
+	msg, err := client.codec.Marshal(msgType, data)
+	if err != nil {
+		h.log.Error("marshal failed", "type", msgType, "err", err)
+		return
+	}
+	h.sendTo(client, msgType, msg)
+}
+
+// sendTo queues an encoded message for one client. If its send queue is
+// full, the oldest queued snapshot makes room under the DropOldest policy;
+// otherwise the client is evicted. MUST be called only from the Hub.Run goroutine.
+func (h *Hub) sendTo(client *Client, msgType protocol.MessageType, msg []byte) {
+	out := outgoing{msgType, msg}
+	if !client.send.push(out) {
+		if h.slowPolicy != DropOldest || !client.send.dropOldest(protocol.MsgState) {
+			h.evict(client)
+			return
+		}
+		h.metrics.SnapshotsDropped.Add(1)
+		// Only the hub pushes, so the freed slot is still there.
+		client.send.push(out)
+	}
+	h.metrics.messageOut(msgType, len(msg))
+}
+
+// dropClient removes a client and its player and closes its send queue.
+// MUST be called only from the Hub.Run goroutine.
+func (h *Hub) dropClient(client *Client) {
+	h.detachClient(client)
+	h.State.RemovePlayer(client.ID)
+}
+
+// detachClient removes a client and closes its send queue but leaves its
+// player in the game. MUST be called only from the Hub.Run goroutine.
+func (h *Hub) detachClient(client *Client) {
+	delete(h.clients, client)
+	delete(h.pending, client.ID)
+	client.send.close()
+	h.members.Add(-1)
+}
+
+// step advances the simulation by one tick: it applies queued inputs to the
+// game state and sends one snapshot to every client. MUST be called only from
+// Run.
+func (h *Hub) step() {
+	framesPerTick := float64(sim.FrameRate) / float64(h.TickRate)
+	for id, q := range h.pending {
+		// Allow a little banked credit to absorb network jitter.
+		q.credit = min(q.credit+framesPerTick, 2*framesPerTick)
+		n := 0
+		for n < len(q.inputs) && q.credit >= 1 {
+			h.State.ApplyInput(id, q.inputs[n])
+			q.credit--
+			n++
 		}
+		q.inputs = q.inputs[n:]
+		if len(q.inputs) == 0 {
+			delete(h.pending, id)
+		}
+	}
+	h.tick++
+
+	for _, id := range h.State.Expire(time.Now()) {
+		h.broadcastMessage(protocol.MsgLeave, protocol.LeaveData{ID: id})
+		h.log.Info("player session expired", "player", id)
+	}
+
+	players := h.snapshotPlayers()
+	now := time.Now().UnixMilli()
+	h.history[h.tick%historySize] = tickSnapshot{tick: h.tick, players: players}
+	if len(h.clients) == 0 {
+		return
+	}
+
+	keyframeTicks := uint32(keyframeInterval.Seconds() * float64(h.TickRate))
+	keyframes := make(map[protocol.Codec][]byte, 2)
+	for client := range h.clients {
+		var msg []byte
+		base, ok := h.snapshotAt(client.ackTick.Load())
+		if !ok || !client.delta || h.tick-client.lastKeyframe >= keyframeTicks {
+			if msg, ok = keyframes[client.codec]; !ok {
+				msg, _ = client.codec.Marshal(protocol.MsgState, protocol.StateData{
+					Tick: h.tick, Players: protocol.PlayerList(players), Time: now,
+				})
+				keyframes[client.codec] = msg
+			}
+			client.lastKeyframe = h.tick
+		} else {
+			changed, removed := protocol.Diff(base.players, players)
+			msg, _ = client.codec.Marshal(protocol.MsgState, protocol.StateData{
+				Tick: h.tick, Base: base.tick, Changed: changed, Removed: removed, Time: now,
+			})
+		}
+
+		h.metrics.SendQueueDepth.Observe(float64(client.send.len()))
+		h.sendState(client, msg)
 	}
 }
 
-// buildStateMessage creates a MsgState envelope from the current game state.
-func (h *Hub) buildStateMessage() []byte {
+// snapshotAt returns the snapshot broadcast at tick, if still in history.
+func (h *Hub) snapshotAt(tick uint32) (tickSnapshot, bool) {
+	if tick == 0 {
+		return tickSnapshot{}, false
+	}
+	snap := h.history[tick%historySize]
+	return snap, snap.tick == tick && snap.players != nil
+}
+
+// snapshotPlayers returns the current game state as protocol player infos.
+func (h *Hub) snapshotPlayers() map[string]protocol.PlayerInfo {
 	snap := h.State.Snapshot()
-	players := make([]protocol.PlayerInfo, 0, len(snap))
+	players := make(map[string]protocol.PlayerInfo, len(snap))
 	for id, ps := range snap {
-		players = append(players, protocol.PlayerInfo{
-			ID: id, X: ps.X, Y: ps.Y, Color: ps.Color,
-		})
+		players[id] = protocol.PlayerInfo{
+			ID: id, Name: ps.Name, X: ps.X, Y: ps.Y, Color: ps.Color, LastSeq: ps.LastSeq,
+		}
 	}
-	msg, _ := protocol.Marshal(protocol.MsgState, protocol.StateData{Players: players})
-	return msg
+	return players
 }
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"ebiten-fullstack-template/internal/protocol"
+	"ebiten-fullstack-template/internal/sim"
+)
+
+// connPair returns both ends of a WebSocket connection.
+func connPair(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server = <-accepted
+	t.Cleanup(func() {
+		_ = client.CloseNow()
+		_ = server.CloseNow()
+	})
+	return server, client
+}
+
+// newTestHub returns a hub that is not running, so the test goroutine may
+// call the methods reserved for Run.
+func newTestHub(cfg Config) (*Hub, *RoomManager) {
+	rooms := NewRoomManager(cfg, NewMetrics())
+	h := NewHub(cfg, rooms.metrics)
+	h.Code = "test"
+	h.Commands = rooms.Commands
+	return h, rooms
+}
+
+// addTestClient adds a client with a player to h without running its pumps
+// and returns it with the far end of its connection.
+func addTestClient(t *testing.T, h *Hub, rooms *RoomManager, id string) (*Client, *websocket.Conn) {
+	t.Helper()
+	serverConn, clientConn := connPair(t)
+	c := NewClient(rooms, serverConn, id, protocol.JSONCodec)
+	c.hub = h
+	h.clients[c] = true
+	h.members.Add(1)
+	h.State.AddPlayer(id, 100, 100, playerColors[0], newSessionToken())
+	return c, clientConn
+}
+
+// takeState pops everything queued for c and returns the last state
+// snapshot.
+func takeState(t *testing.T, c *Client) protocol.StateData {
+	t.Helper()
+	var state protocol.StateData
+	found := false
+	for {
+		msg, ok, _ := c.send.pop()
+		if !ok {
+			break
+		}
+		if msg.msgType != protocol.MsgState {
+			continue
+		}
+		env, err := protocol.JSONCodec.Unmarshal(msg.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state = protocol.StateData{}
+		if err := env.Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatalf("no state snapshot queued for %s", c.ID)
+	}
+	return state
+}
+
+func TestStepKeyframesAndDeltas(t *testing.T) {
+	h, rooms := newTestHub(DefaultConfig())
+	silent, _ := addTestClient(t, h, rooms, "silent")
+	acking, _ := addTestClient(t, h, rooms, "acking")
+	legacy, _ := addTestClient(t, h, rooms, "legacy")
+	silent.delta, acking.delta = true, true
+	legacy.ackTick.Store(1) // acks are ignored without the delta feature
+
+	keyframeTicks := int(keyframeInterval.Seconds() * float64(h.TickRate))
+	for i := 1; i <= keyframeTicks+2; i++ {
+		// Move someone so that deltas are not empty.
+		h.State.Teleport("silent", float64(i), 0)
+		h.step()
+
+		if s := takeState(t, silent); !s.IsKeyframe() || len(s.Players) != 3 {
+			t.Fatalf("tick %d: client without acks got base %d with %d players, want a full keyframe",
+				s.Tick, s.Base, len(s.Players))
+		}
+		if s := takeState(t, legacy); !s.IsKeyframe() {
+			t.Fatalf("tick %d: client without delta support got a delta", s.Tick)
+		}
+
+		s := takeState(t, acking)
+		switch {
+		case i == 1:
+			if !s.IsKeyframe() {
+				t.Fatalf("tick %d: first snapshot is a delta", s.Tick)
+			}
+		case i == keyframeTicks+1:
+			if !s.IsKeyframe() {
+				t.Fatalf("tick %d: no keyframe after %d ticks", s.Tick, keyframeTicks)
+			}
+		default:
+			if s.IsKeyframe() || s.Base != s.Tick-1 {
+				t.Fatalf("tick %d: acking client got base %d, want a delta against %d", s.Tick, s.Base, s.Tick-1)
+			}
+			if len(s.Changed) != 1 || s.Changed[0].ID != "silent" || len(s.Removed) != 0 {
+				b, _ := json.Marshal(s)
+				t.Fatalf("tick %d: delta %s, want only silent changed", s.Tick, b)
+			}
+		}
+		acking.ackTick.Store(s.Tick)
+	}
+}
+
+func TestStepAckOutsideHistory(t *testing.T) {
+	h, rooms := newTestHub(DefaultConfig())
+	c, _ := addTestClient(t, h, rooms, "p1")
+	c.delta = true
+
+	h.step()
+	c.ackTick.Store(takeState(t, c).Tick)
+	for range historySize {
+		h.step()
+	}
+	if s := takeState(t, c); !s.IsKeyframe() {
+		t.Errorf("tick %d: got a delta against tick %d, which left the history", s.Tick, s.Base)
+	}
+}
+
+func TestStepLimitsInputRate(t *testing.T) {
+	h, rooms := newTestHub(DefaultConfig())
+	c, _ := addTestClient(t, h, rooms, "p1")
+	framesPerTick := float64(sim.FrameRate) / float64(h.TickRate)
+	maxStep := 2 * framesPerTick * sim.PlayerSpeed
+
+	var seq uint32
+	x := 100.0
+	for tick := range 10 {
+		// Far more inputs than a client produces in one tick.
+		for range 50 {
+			seq++
+			h.queueInput(clientInput{client: c, input: protocol.InputData{Seq: seq, Right: true}})
+		}
+		h.step()
+		ps, _ := h.State.Player("p1")
+		if moved := ps.X - x; moved <= 0 || moved > maxStep {
+			t.Fatalf("tick %d: moved %v, want up to %v", tick, moved, maxStep)
+		}
+		x = ps.X
+	}
+
+	// Drain the queue, then replay inputs that were already applied.
+	for len(h.pending) > 0 {
+		h.step()
+	}
+	before, _ := h.State.Player("p1")
+	for _, old := range []uint32{before.LastSeq, before.LastSeq - 1, 1} {
+		h.queueInput(clientInput{client: c, input: protocol.InputData{Seq: old, Left: true}})
+	}
+	h.step()
+	if after, _ := h.State.Player("p1"); after.X != before.X || after.LastSeq != before.LastSeq {
+		t.Errorf("replayed inputs moved the player from %v (seq %d) to %v (seq %d)",
+			before.X, before.LastSeq, after.X, after.LastSeq)
+	}
+}
+
+func TestResumeRestartsInputSequence(t *testing.T) {
+	h, rooms := newTestHub(DefaultConfig())
+	old, _ := addTestClient(t, h, rooms, "p1")
+	for seq := uint32(1); seq <= 5; seq++ {
+		h.queueInput(clientInput{client: old, input: protocol.InputData{Seq: seq, Right: true}})
+	}
+	h.step()
+	// The old connection still has inputs queued when its token is used.
+	for seq := uint32(6); seq <= 10; seq++ {
+		h.queueInput(clientInput{client: old, input: protocol.InputData{Seq: seq, Right: true}})
+	}
+	ps, _ := h.State.Player("p1")
+	if _, ok := h.State.Resume(ps.Token); !ok {
+		t.Fatal("token did not resume")
+	}
+	// A tick runs before the hub registers the new connection.
+	h.step()
+	ps, _ = h.State.Player("p1")
+
+	serverConn, _ := connPair(t)
+	c := NewClient(rooms, serverConn, "p1", protocol.JSONCodec)
+	c.hub, c.resumed = h, true
+	h.clients[c] = true
+	h.members.Add(1)
+	if !h.resume(c) {
+		t.Fatal("resume failed")
+	}
+	h.queueInput(clientInput{client: old, input: protocol.InputData{Seq: 11, Right: true}})
+	h.queueInput(clientInput{client: c, input: protocol.InputData{Seq: 1, Down: true}})
+	h.step()
+
+	after, _ := h.State.Player("p1")
+	if after.LastSeq != 1 {
+		t.Errorf("last seq %d after the resumed client's first input, want 1", after.LastSeq)
+	}
+	if after.Y <= ps.Y {
+		t.Error("the resumed client's first input was dropped")
+	}
+	if after.X != ps.X {
+		t.Errorf("the old connection's inputs moved the player from x %v to %v", ps.X, after.X)
+	}
+}
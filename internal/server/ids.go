@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+)
+
+// IDAllocator hands out player IDs. Implementations must be safe for
+// concurrent use, since every WebSocket handler allocates from its own
+// goroutine.
+type IDAllocator interface {
+	NextID() string
+}
+
+// RandomIDs allocates opaque player IDs that cannot be guessed from earlier
+// ones: random bytes from crypto/rand plus an atomic counter, so IDs never
+// repeat within a process and practically never across restarts.
+type RandomIDs struct {
+	counter atomic.Uint64
+}
+
+// NextID implements IDAllocator.
+func (a *RandomIDs) NextID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	n := a.counter.Add(1)
+	return "p-" + hex.EncodeToString(b) + strconv.FormatUint(n, 36)
+}
+
+// SequentialIDs allocates predictable IDs "player-1", "player-2", ... for
+// tests and local debugging.
+type SequentialIDs struct {
+	counter atomic.Uint64
+}
+
+// NextID implements IDAllocator.
+func (a *SequentialIDs) NextID() string {
+	return "player-" + strconv.FormatUint(a.counter.Add(1), 10)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// handleLobby processes a message from a client that has not entered a room
+// yet. MUST be called only from the client's ReadPump.
+func (c *Client) handleLobby(env protocol.Envelope) {
+	switch env.Type {
+	case protocol.MsgListRooms:
+		c.reply(protocol.MsgRoomList, protocol.RoomListData{Rooms: c.rooms.List()})
+
+	case protocol.MsgCreateRoom:
+		var req protocol.CreateRoomData
+		if err := env.Decode(&req); err != nil {
+			c.logBadMessage("malformed message", "type", env.Type, "err", err)
+			return
+		}
+		c.enterRoom(c.rooms.Create(req.Private))
+
+	case protocol.MsgJoinRoom:
+		var req protocol.JoinRoomData
+		if err := env.Decode(&req); err != nil {
+			c.logBadMessage("malformed message", "type", env.Type, "err", err)
+			return
+		}
+		hub, ok := c.rooms.Join(req.Code)
+		if !ok {
+			c.reply(protocol.MsgJoinResult, protocol.JoinResultData{
+				Error: "no room with code " + req.Code,
+			})
+			return
+		}
+		if hub.banned(c.remote, time.Now()) {
+			c.rooms.Release(hub)
+			c.reply(protocol.MsgJoinResult, protocol.JoinResultData{
+				Error: "you were kicked from room " + hub.Code + "; try again later",
+			})
+			return
+		}
+		c.enterRoom(hub)
+
+	case protocol.MsgSetName:
+		// Uniqueness is checked when the player enters a room.
+		var req protocol.SetNameData
+		if err := env.Decode(&req); err != nil {
+			c.logBadMessage("malformed message", "type", env.Type, "err", err)
+			return
+		}
+		name, err := normalizeName(req.Name)
+		if err != nil {
+			c.reply(protocol.MsgNameResult, protocol.NameResultData{Error: err.Error()})
+			return
+		}
+		c.name = name
+		c.reply(protocol.MsgNameResult, protocol.NameResultData{OK: true, Name: name})
+
+	default:
+		c.logBadMessage("unexpected message in lobby", "type", env.Type)
+	}
+}
+
+// enterRoom confirms the join to the client and registers it with hub, which
+// owns the client's send queue from then on. hub must have been reserved
+// through the RoomManager.
+func (c *Client) enterRoom(hub *Hub) {
+	c.reply(protocol.MsgJoinResult, protocol.JoinResultData{OK: true, Code: hub.Code})
+	c.hub = hub
+	c.setRoom(hub.Code)
+	hub.register <- c
+}
+
+// reply queues a message for a client that is not in a room, dropping it if
+// the send queue is full.
+func (c *Client) reply(msgType protocol.MessageType, data interface{}) {
+	msg, err := c.codec.Marshal(msgType, data)
+	if err != nil {
+		c.logger().Error("marshal failed", "type", msgType, "err", err)
+		return
+	}
+	if !c.send.push(outgoing{msgType, msg}) {
+		c.logger().Warn("send buffer full, dropping message", "type", msgType)
+		return
+	}
+	c.metrics.messageOut(msgType, len(msg))
+}
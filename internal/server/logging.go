@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewLogger creates the server's logger writing to w in the configured
+// format and level.
+func NewLogger(cfg Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// parseLevel parses a level name such as "debug" or "warn".
+func parseLevel(v string, dst *slog.Level) error {
+	return dst.UnmarshalText([]byte(strings.ToUpper(v)))
+}
+
+// logSampler limits a noisy log line to burst messages per interval. It is
+// safe for concurrent use.
+type logSampler struct {
+	interval time.Duration
+	burst    int
+
+	mu         sync.Mutex
+	start      time.Time
+	n          int
+	suppressed int
+}
+
+// badMessages samples logs about malformed or unexpected client messages,
+// which a single misbehaving client can produce at its full message rate.
+var badMessages = &logSampler{interval: time.Second, burst: 10}
+
+// allow reports whether to log a message at time now, and how many were
+// suppressed since the last one logged.
+func (s *logSampler) allow(now time.Time) (ok bool, suppressed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.start) >= s.interval {
+		s.start = now
+		s.n = 0
+	}
+	if s.n >= s.burst {
+		s.suppressed++
+		return false, 0
+	}
+	s.n++
+	suppressed, s.suppressed = s.suppressed, 0
+	return true, suppressed
+}
+
+// logBadMessage logs a malformed or unexpected message from the client at
+// warn level, sampled with badMessages.
+func (c *Client) logBadMessage(msg string, args ...any) {
+	ok, suppressed := badMessages.allow(time.Now())
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	c.logger().Warn(msg, args...)
+}
+
+// logger returns the client's logger, which carries its player ID, remote
+// address and room.
+func (c *Client) logger() *slog.Logger {
+	return c.log.Load()
+}
+
+// setRoom adds the room to the client's log context.
+func (c *Client) setRoom(code string) {
+	c.log.Store(c.baseLog.With("room", code))
+}
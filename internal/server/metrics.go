@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// Metrics counts server events and is exported in the Prometheus text
+// format on /metrics. All fields are safe for concurrent use.
+type Metrics struct {
+	// MessagesThrottled counts inbound messages dropped by the per-client
+	// rate limit.
+	MessagesThrottled atomic.Uint64
+
+	// ClientsRateLimited counts clients disconnected for flooding.
+	ClientsRateLimited atomic.Uint64
+
+	// ConnectionsRejected counts connections refused by the per-IP limit.
+	ConnectionsRejected atomic.Uint64
+
+	// ClientsConnected is the number of open WebSocket connections.
+	ClientsConnected atomic.Int64
+
+	// SlowClientDrops counts clients disconnected because their send buffer
+	// was full.
+	SlowClientDrops atomic.Uint64
+
+	// SnapshotsDropped counts state snapshots discarded or replaced before
+	// reaching slow clients.
+	SnapshotsDropped atomic.Uint64
+
+	// BytesSent counts encoded message bytes queued to clients.
+	BytesSent atomic.Uint64
+
+	// Messages read from and queued to clients, by message type.
+	messagesIn, messagesOut labeledCounter
+
+	// SendQueueDepth samples every client's send queue once per tick.
+	SendQueueDepth *Histogram
+
+	// HubLoopLatency is how late hubs start their ticks, i.e. how long the
+	// hub loop was busy with other events.
+	HubLoopLatency *Histogram
+
+	// TickDuration is how long one simulation tick takes.
+	TickDuration *Histogram
+
+	// RTT is the round-trip time measured by heartbeat pings.
+	RTT *Histogram
+}
+
+// NewMetrics creates an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		SendQueueDepth: NewHistogram(0, 1, 4, 16, 64, 128, 192, 256),
+		HubLoopLatency: NewHistogram(0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1),
+		TickDuration:   NewHistogram(0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05),
+		RTT:            NewHistogram(0.01, 0.025, 0.05, 0.1, 0.15, 0.2, 0.3, 0.5, 1),
+	}
+}
+
+// messageIn counts a message read from a client. Clients choose the types
+// they send, so types this build does not know share one "unknown" label.
+func (m *Metrics) messageIn(msgType protocol.MessageType) {
+	if !msgType.Known() {
+		msgType = "unknown"
+	}
+	m.messagesIn.add(string(msgType))
+}
+
+// messageOut counts a message of n bytes queued to a client.
+func (m *Metrics) messageOut(msgType protocol.MessageType, n int) {
+	m.messagesOut.add(string(msgType))
+	m.BytesSent.Add(uint64(n))
+}
+
+// labeledCounter is a set of counters keyed by one label value. The values
+// must come from a small, fixed set, as each one is kept for good.
+type labeledCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (c *labeledCounter) add(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]uint64)
+	}
+	c.counts[label]++
+}
+
+// snapshot returns the counts sorted by label.
+func (c *labeledCounter) snapshot() (labels []string, counts []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for label := range c.counts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		counts = append(counts, c.counts[label])
+	}
+	return labels, counts
+}
+
+// Histogram counts observations in cumulative buckets, Prometheus style.
+// It is safe for concurrent use.
+type Histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, plus +Inf last
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given ascending bucket upper
+// bounds.
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	s.Metrics.write(bw, s.Rooms.Stats(), time.Since(s.started))
+	_ = bw.Flush()
+}
+
+// write renders all metrics.
+func (m *Metrics) write(w io.Writer, rooms RoomStats, uptime time.Duration) {
+	gauge(w, "game_uptime_seconds", "Seconds since the server started.", uptime.Seconds())
+	gauge(w, "game_rooms", "Running rooms.", float64(rooms.Rooms))
+	gauge(w, "game_players", "Players in rooms, including disconnected players in their grace period.", float64(rooms.Players))
+	gauge(w, "game_clients_connected", "Open WebSocket connections.", float64(m.ClientsConnected.Load()))
+
+	counter(w, "game_bytes_sent_total", "Encoded message bytes queued to clients.", m.BytesSent.Load())
+	counter(w, "game_slow_client_drops_total", "Clients disconnected because their send buffer was full.", m.SlowClientDrops.Load())
+	counter(w, "game_snapshots_dropped_total", "State snapshots discarded or replaced before reaching slow clients.", m.SnapshotsDropped.Load())
+	counter(w, "game_messages_throttled_total", "Inbound messages dropped by the per-client rate limit.", m.MessagesThrottled.Load())
+	counter(w, "game_clients_rate_limited_total", "Clients disconnected for exceeding the message rate limit.", m.ClientsRateLimited.Load())
+	counter(w, "game_connections_rejected_total", "Connections refused by the per-IP rate limit.", m.ConnectionsRejected.Load())
+
+	labeled(w, "game_messages_received_total", "Messages read from clients by type.", &m.messagesIn)
+	labeled(w, "game_messages_sent_total", "Messages queued to clients by type.", &m.messagesOut)
+
+	histogram(w, "game_send_queue_depth", "Messages waiting in each client's send queue, sampled every tick.", m.SendQueueDepth)
+	histogram(w, "game_hub_loop_latency_seconds", "How late each hub tick starts.", m.HubLoopLatency)
+	histogram(w, "game_tick_duration_seconds", "Time to run one simulation tick.", m.TickDuration)
+	histogram(w, "game_rtt_seconds", "Client round-trip times measured by heartbeat pings.", m.RTT)
+}
+
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func gauge(w io.Writer, name, help string, v float64) {
+	header(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func counter(w io.Writer, name, help string, v uint64) {
+	header(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+func labeled(w io.Writer, name, help string, c *labeledCounter) {
+	header(w, name, help, "counter")
+	labels, counts := c.snapshot()
+	for i, label := range labels {
+		fmt.Fprintf(w, "%s{type=\"%s\"} %d\n", name, labelEscaper.Replace(label), counts[i])
+	}
+}
+
+func histogram(w io.Writer, name, help string, h *Histogram) {
+	header(w, name, help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.sum), name, h.count)
+}
+
+// labelEscaper escapes label values for the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+func TestMessageInLabels(t *testing.T) {
+	m := NewMetrics()
+	for i := range 100 {
+		m.messageIn(protocol.MessageType(fmt.Sprintf("made-up-%d", i)))
+	}
+	m.messageIn(protocol.MsgInput)
+	m.messageIn(protocol.MsgInput)
+
+	labels, counts := m.messagesIn.snapshot()
+	if want := []string{"input", "unknown"}; !slices.Equal(labels, want) {
+		t.Fatalf("labels %v, want %v", labels, want)
+	}
+	if want := []uint64{2, 100}; !slices.Equal(counts, want) {
+		t.Errorf("counts %v, want %v", counts, want)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	minNameLength = 2
+	maxNameLength = 16
+)
+
+// reservedNames may not be used as display names, in any letter case, so
+// players cannot impersonate the server.
+var reservedNames = []string{"admin", "administrator", "moderator", "server", "system", "host"}
+
+// normalizeName trims and validates a requested display name. It returns
+// the name to store or an error suitable for showing to the player.
+func normalizeName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if n := utf8.RuneCountInString(name); n < minNameLength || n > maxNameLength {
+		return "", errors.New("name must be 2 to 16 characters long")
+	}
+	for _, r := range name {
+		if !isNameRune(r) {
+			return "", errors.New("name may only contain letters A-Z, digits, spaces and _ - .")
+		}
+	}
+	for _, reserved := range reservedNames {
+		if strings.EqualFold(name, reserved) {
+			return "", errors.New("name " + name + " is reserved")
+		}
+	}
+	return name, nil
+}
+
+// isNameRune reports whether r may appear in a display name. Names are
+// ASCII only: nameplates are drawn with a font that has no other scripts,
+// and lookalike letters from them would get past the reserved and taken
+// name checks.
+func isNameRune(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return r == ' ' || r == '_' || r == '-' || r == '.'
+}
+
+// SetName gives a player a display name unless another player in the game
+// already uses it, ignoring letter case.
+func (gs *GameState) SetName(id, name string) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	p, ok := gs.Players[id]
+	if !ok {
+		return errors.New("player not found")
+	}
+	for otherID, other := range gs.Players {
+		if otherID != id && strings.EqualFold(other.Name, name) {
+			return errors.New("name " + name + " is already taken")
+		}
+	}
+	p.Name = name
+	return nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "Ada", want: "Ada"},
+		{in: "  Ada   Lovelace ", want: "Ada Lovelace"},
+		{in: "a_b-c.d 42", want: "a_b-c.d 42"},
+		{in: "Ab", want: "Ab"},
+		{in: "abcdefghijklmnop", want: "abcdefghijklmnop"},
+		{in: "A", wantErr: "2 to 16"},
+		{in: "   A   ", wantErr: "2 to 16"},
+		{in: "abcdefghijklmnopq", wantErr: "2 to 16"},
+		{in: "Zoë", wantErr: "only contain"},
+		{in: "Аdmin", wantErr: "only contain"}, // Cyrillic А
+		{in: "名前", wantErr: "only contain"},
+		{in: "١٢٣", wantErr: "only contain"}, // Arabic-Indic digits
+		{in: "ab!", wantErr: "only contain"},
+		{in: "a\tb", want: "a b"},
+		{in: "ADMIN", wantErr: "reserved"},
+		{in: " server ", wantErr: "reserved"},
+		{in: "servers", want: "servers"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeName(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("normalizeName(%q) = %q, %v; want an error mentioning %q", tt.in, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeName(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
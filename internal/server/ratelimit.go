@@ -0,0 +1,123 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token-bucket rate limiter: it holds up to burst tokens,
+// refills at rate tokens per second and every allowed event takes one. It is
+// not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket.
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// allow takes a token if one is available at time now.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RateLimits bounds how fast clients may send messages and open connections.
+// A zero rate disables the corresponding limit.
+type RateLimits struct {
+	// MessageRate and MessageBurst limit inbound messages per connection.
+	// Messages over the limit are dropped; a client that keeps sending at
+	// more than twice the limit is disconnected.
+	MessageRate  float64
+	MessageBurst float64
+
+	// ConnectionRate and ConnectionBurst limit new connections per remote IP.
+	// Connections over the limit are refused with 429 Too Many Requests.
+	ConnectionRate  float64
+	ConnectionBurst float64
+}
+
+// DefaultRateLimits leave room for an input every frame plus acks, chat and
+// a reload or two, while stopping floods.
+var DefaultRateLimits = RateLimits{
+	MessageRate:     120,
+	MessageBurst:    240,
+	ConnectionRate:  1,
+	ConnectionBurst: 10,
+}
+
+// ipLimiter keeps one token bucket per remote IP. It is safe for concurrent
+// use.
+type ipLimiter struct {
+	rate, burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// newIPLimiter creates a limiter allowing burst connections per IP at once
+// and rate per second after that.
+func newIPLimiter(rate, burst float64) *ipLimiter {
+	return &ipLimiter{rate: rate, burst: burst, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from ip's bucket if one is available at time now.
+func (l *ipLimiter) allow(ip string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget addresses whose buckets have refilled; a fresh bucket is the same.
+	if refill := time.Duration(l.burst / l.rate * float64(time.Second)); now.Sub(l.lastPrune) >= refill {
+		for addr, b := range l.buckets {
+			if now.Sub(b.last) >= refill {
+				delete(l.buckets, addr)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[ip] = b
+	}
+	return b.allow(now)
+}
+
+// limitMessages rate-limits the client's inbound messages to rate per second
+// with the given burst. Must be called before ReadPump starts.
+func (c *Client) limitMessages(rate, burst float64) {
+	if rate <= 0 {
+		return
+	}
+	c.inbound = newTokenBucket(rate, burst)
+	c.flood = newTokenBucket(rate, burst)
+}
+
+// throttle applies the inbound message limit to a message read at time now.
+// It reports whether to drop the message and whether the client is flooding
+// and must be disconnected. Only the ReadPump goroutine may call it.
+func (c *Client) throttle(now time.Time) (drop, flooding bool) {
+	if c.inbound == nil || c.inbound.allow(now) {
+		return false, false
+	}
+	c.metrics.MessagesThrottled.Add(1)
+	// Dropped messages drain a second bucket, so only clients sending at
+	// more than twice the limit run it dry.
+	return true, !c.flood.allow(now)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(10, 5)
+	for i := range 5 {
+		if !b.allow(start) {
+			t.Fatalf("burst event %d refused", i+1)
+		}
+	}
+	if b.allow(start) {
+		t.Fatal("event beyond the burst allowed")
+	}
+
+	// One token comes back every 100ms.
+	if b.allow(start.Add(50 * time.Millisecond)) {
+		t.Error("allowed before a token refilled")
+	}
+	if !b.allow(start.Add(150 * time.Millisecond)) {
+		t.Error("refused after a token refilled")
+	}
+
+	// A long pause refills no more than the burst.
+	later := start.Add(time.Minute)
+	allowed := 0
+	for b.allow(later) {
+		allowed++
+	}
+	if allowed != 5 {
+		t.Errorf("%d events allowed after a long pause, want the burst of 5", allowed)
+	}
+}
+
+// sendAt feeds throttle messages at rate per second for d and returns how
+// many were dropped and whether the client was found flooding.
+func sendAt(c *Client, start time.Time, rate float64, d time.Duration) (dropped int, flooding bool) {
+	interval := time.Duration(float64(time.Second) / rate)
+	for at := start; at.Before(start.Add(d)); at = at.Add(interval) {
+		drop, flood := c.throttle(at)
+		if drop {
+			dropped++
+		}
+		if flood {
+			return dropped, true
+		}
+	}
+	return dropped, false
+}
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name         string
+		rate         float64 // messages per second sent, against a limit of 10
+		wantDrops    bool
+		wantFlooding bool
+	}{
+		{name: "under the limit", rate: 8},
+		{name: "over the limit", rate: 15, wantDrops: true},
+		{name: "just under twice the limit", rate: 19, wantDrops: true},
+		{name: "over twice the limit", rate: 25, wantDrops: true, wantFlooding: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{metrics: NewMetrics()}
+			c.limitMessages(10, 5)
+			dropped, flooding := sendAt(c, time.Now(), tt.rate, time.Minute)
+			if (dropped > 0) != tt.wantDrops {
+				t.Errorf("dropped %d messages, want drops: %v", dropped, tt.wantDrops)
+			}
+			if flooding != tt.wantFlooding {
+				t.Errorf("flooding = %v, want %v", flooding, tt.wantFlooding)
+			}
+			if got := c.metrics.MessagesThrottled.Load(); got != uint64(dropped) {
+				t.Errorf("throttled metric %d, want %d", got, dropped)
+			}
+		})
+	}
+
+	unlimited := &Client{metrics: NewMetrics()}
+	unlimited.limitMessages(0, 0)
+	if dropped, flooding := sendAt(unlimited, time.Now(), 1000, time.Second); dropped > 0 || flooding {
+		t.Errorf("a zero rate dropped %d messages (flooding %v), want no limit", dropped, flooding)
+	}
+}
+
+func TestIPLimiter(t *testing.T) {
+	start := time.Now()
+	l := newIPLimiter(1, 2)
+	if !l.allow("a", start) || !l.allow("a", start) {
+		t.Fatal("burst refused")
+	}
+	if l.allow("a", start) {
+		t.Error("connection beyond the burst allowed")
+	}
+	if !l.allow("b", start) {
+		t.Error("another address shares the first one's bucket")
+	}
+
+	// Buckets that have refilled are forgotten on the next call.
+	refilled := start.Add(2 * time.Second)
+	if !l.allow("c", refilled) {
+		t.Fatal("new address refused")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets kept after pruning, want only the new address", len(l.buckets))
+	}
+	if !l.allow("a", refilled) || !l.allow("a", refilled) {
+		t.Error("pruned address did not get its burst back")
+	}
+
+	off := newIPLimiter(0, 0)
+	for range 100 {
+		if !off.allow("a", start) {
+			t.Fatal("a zero rate refused a connection")
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+const (
+	// roomGCInterval is how often empty rooms are looked for.
+	roomGCInterval = 10 * time.Second
+
+	// roomIdleTimeout is how long a room may stay empty before its hub is
+	// stopped and removed.
+	roomIdleTimeout = 30 * time.Second
+)
+
+// joinCodeAlphabet avoids look-alike characters; its length divides 256 so
+// random bytes map onto it without bias.
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// joinCodeLength is the length of generated join codes.
+const joinCodeLength = 5
+
+// roomCodePattern restricts room codes to short URL-safe names.
+var roomCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// ValidRoomCode reports whether code can name a room.
+func ValidRoomCode(code string) bool {
+	return roomCodePattern.MatchString(code)
+}
+
+// room is a running hub plus the bookkeeping the manager needs to collect it.
+type room struct {
+	hub *Hub
+
+	// private rooms are left out of room listings.
+	private bool
+
+	// emptySince is when the room was first seen empty; zero while occupied.
+	emptySince time.Time
+}
+
+// RoomManager runs one Hub per room, creating hubs on demand and stopping
+// them once they have been empty for a while.
+type RoomManager struct {
+	mu    sync.Mutex
+	rooms map[string]*room
+
+	// Commands are given to every new room. Set before rooms are created.
+	Commands *CommandRegistry
+
+	config  Config
+	metrics *Metrics
+	stop    chan struct{}
+}
+
+// NewRoomManager creates a RoomManager whose hubs and clients use cfg and
+// record into metrics.
+func NewRoomManager(cfg Config, metrics *Metrics) *RoomManager {
+	return &RoomManager{
+		rooms:    make(map[string]*room),
+		Commands: DefaultCommands(),
+		config:   cfg,
+		metrics:  metrics,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Acquire returns the hub for room code, creating a public room if needed,
+// and reserves a member slot so the room is not collected before the caller
+// registers its client.
+func (m *RoomManager) Acquire(code string) *Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rooms[code]
+	if !ok {
+		r = m.createLocked(code, false)
+	}
+	return m.reserveLocked(r)
+}
+
+// Join is like Acquire but only enters an existing room. Codes are typed by
+// players, so if no room has exactly this code, one whose code differs only
+// in case is entered.
+func (m *RoomManager) Join(code string) (*Hub, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rooms[code]
+	if !ok {
+		for other, candidate := range m.rooms {
+			if strings.EqualFold(other, code) {
+				r, ok = candidate, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return m.reserveLocked(r), true
+}
+
+// Release gives back a member slot reserved by Acquire or Join for a client
+// that does not enter the room after all.
+func (m *RoomManager) Release(hub *Hub) {
+	hub.members.Add(-1)
+}
+
+// Create starts a room under a fresh join code and reserves a member slot in
+// it like Acquire.
+func (m *RoomManager) Create(private bool) *Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := newJoinCode()
+	for m.rooms[code] != nil {
+		code = newJoinCode()
+	}
+	return m.reserveLocked(m.createLocked(code, private))
+}
+
+// createLocked starts a hub for a new room. m.mu must be held.
+func (m *RoomManager) createLocked(code string, private bool) *room {
+	r := &room{hub: NewHub(m.config, m.metrics), private: private}
+	r.hub.Code = code
+	r.hub.log = slog.With("room", code)
+	r.hub.Commands = m.Commands
+	m.rooms[code] = r
+	go r.hub.Run()
+	r.hub.log.Info("room created", "rooms", len(m.rooms))
+	return r
+}
+
+// reserveLocked counts a joining client in r. m.mu must be held.
+func (m *RoomManager) reserveLocked(r *room) *Hub {
+	r.hub.members.Add(1)
+	r.emptySince = time.Time{}
+	return r.hub
+}
+
+// newJoinCode returns a random join code.
+func newJoinCode() string {
+	b := make([]byte, joinCodeLength)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// List returns the public rooms ordered by code.
+func (m *RoomManager) List() []protocol.RoomInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rooms := make([]protocol.RoomInfo, 0, len(m.rooms))
+	for code, r := range m.rooms {
+		if r.private {
+			continue
+		}
+		rooms = append(rooms, protocol.RoomInfo{
+			Code:    code,
+			Players: int(r.hub.members.Load()),
+		})
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Code < rooms[j].Code })
+	return rooms
+}
+
+// RoomStats summarizes the running rooms.
+type RoomStats struct {
+	Rooms   int `json:"rooms"`
+	Players int `json:"players"` // including disconnected players in their grace period
+	Clients int `json:"clients"` // connected clients in rooms
+}
+
+// Stats counts the running rooms, their players and connected clients.
+func (m *RoomManager) Stats() RoomStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := RoomStats{Rooms: len(m.rooms)}
+	for _, r := range m.rooms {
+		stats.Players += r.hub.State.Len()
+		stats.Clients += int(r.hub.members.Load())
+	}
+	return stats
+}
+
+// Run periodically collects empty rooms until Stop is called. It should be
+// called in its own goroutine.
+func (m *RoomManager) Run() {
+	ticker := time.NewTicker(roomGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.collect(now)
+		}
+	}
+}
+
+// collect stops and removes rooms that have been empty for roomIdleTimeout.
+// Rooms holding disconnected players waiting to resume are not empty.
+func (m *RoomManager) collect(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for code, r := range m.rooms {
+		if r.hub.members.Load() > 0 || r.hub.State.Len() > 0 {
+			r.emptySince = time.Time{}
+			continue
+		}
+		if r.emptySince.IsZero() {
+			r.emptySince = now
+			continue
+		}
+		if now.Sub(r.emptySince) >= roomIdleTimeout {
+			r.hub.Stop()
+			delete(m.rooms, code)
+			r.hub.log.Info("room removed", "rooms", len(m.rooms))
+		}
+	}
+}
+
+// Stop stops the collector and every hub.
+func (m *RoomManager) Stop() {
+	close(m.stop)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for code, r := range m.rooms {
+		r.hub.Stop()
+		delete(m.rooms, code)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJoinIgnoresCase(t *testing.T) {
+	m := NewRoomManager(DefaultConfig(), NewMetrics())
+	defer m.Stop()
+
+	lower := m.Acquire("my-room")
+	upper := m.Create(false)
+	exactLower := m.Acquire("abc")
+	exactUpper := m.Acquire("ABC")
+
+	tests := []struct {
+		code string
+		want *Hub
+	}{
+		{"my-room", lower},
+		{"MY-ROOM", lower},
+		{upper.Code, upper},
+		{strings.ToLower(upper.Code), upper},
+		{"abc", exactLower},
+		{"ABC", exactUpper},
+	}
+	for _, tt := range tests {
+		got, ok := m.Join(tt.code)
+		if !ok {
+			t.Errorf("Join(%q) found no room", tt.code)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Join(%q) entered room %s, want %s", tt.code, got.Code, tt.want.Code)
+		}
+	}
+	if _, ok := m.Join("nope"); ok {
+		t.Error("Join found a room that does not exist")
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"slices"
+	"sync"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// outgoing is an encoded message in a client's send queue.
+type outgoing struct {
+	msgType protocol.MessageType
+	data    []byte
+}
+
+// sendQueue holds a client's outgoing messages in order. The hub pushes and
+// the WritePump pops; unlike a channel, the queue lets the hub take state
+// snapshots out of the middle to apply the slow client policy. It is safe
+// for concurrent use.
+type sendQueue struct {
+	mu     sync.Mutex
+	msgs   []outgoing // oldest first
+	size   int
+	closed bool
+
+	// ready holds a value while messages are queued or the queue is closed.
+	ready chan struct{}
+}
+
+// newSendQueue creates a queue holding at most size messages.
+func newSendQueue(size int) *sendQueue {
+	return &sendQueue{size: size, ready: make(chan struct{}, 1)}
+}
+
+// signal wakes the WritePump. q.mu must be held.
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push appends msg, reporting false if the queue is full or closed.
+func (q *sendQueue) push(msg outgoing) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.msgs) >= q.size {
+		return false
+	}
+	q.msgs = append(q.msgs, msg)
+	q.signal()
+	return true
+}
+
+// dropOldest removes the oldest queued message of msgType, keeping the rest
+// in order. It reports false if there is none.
+func (q *sendQueue) dropOldest(msgType protocol.MessageType) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.IndexFunc(q.msgs, func(m outgoing) bool { return m.msgType == msgType })
+	if i < 0 {
+		return false
+	}
+	q.msgs = slices.Delete(q.msgs, i, i+1)
+	return true
+}
+
+// replace removes every queued message of msg's type and appends msg, so that
+// it follows everything queued before it. It returns the number of messages
+// removed and false if msg did not fit.
+func (q *sendQueue) replace(msg outgoing) (removed int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, false
+	}
+	n := len(q.msgs)
+	q.msgs = slices.DeleteFunc(q.msgs, func(m outgoing) bool { return m.msgType == msg.msgType })
+	removed = n - len(q.msgs)
+	if len(q.msgs) >= q.size {
+		return removed, false
+	}
+	q.msgs = append(q.msgs, msg)
+	q.signal()
+	return removed, true
+}
+
+// pop removes and returns the oldest message. ok is false if the queue is
+// empty; done is set once it is empty and closed.
+func (q *sendQueue) pop() (msg outgoing, ok, done bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.msgs) == 0 {
+		return outgoing{}, false, q.closed
+	}
+	msg = q.msgs[0]
+	q.msgs[0] = outgoing{}
+	q.msgs = q.msgs[1:]
+	return msg, true, false
+}
+
+// len returns the number of queued messages.
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs)
+}
+
+// close stops further pushes. Messages already queued are still popped.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.signal()
+}
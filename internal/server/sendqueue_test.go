@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+func TestSendQueue(t *testing.T) {
+	q := newSendQueue(2)
+	if !q.push(outgoing{msgType: protocol.MsgJoin}) || !q.push(outgoing{msgType: protocol.MsgState}) {
+		t.Fatal("push into a queue with room failed")
+	}
+	if q.push(outgoing{msgType: protocol.MsgLeave}) {
+		t.Fatal("push into a full queue succeeded")
+	}
+	if q.dropOldest(protocol.MsgLeave) {
+		t.Error("dropped a message type that is not queued")
+	}
+
+	q.close()
+	if q.push(outgoing{msgType: protocol.MsgLeave}) {
+		t.Error("push into a closed queue succeeded")
+	}
+	for _, want := range []protocol.MessageType{protocol.MsgJoin, protocol.MsgState} {
+		msg, ok, done := q.pop()
+		if !ok || done || msg.msgType != want {
+			t.Fatalf("pop = %s, %v, %v; want %s, true, false", msg.msgType, ok, done, want)
+		}
+	}
+	if _, ok, done := q.pop(); ok || !done {
+		t.Errorf("pop from an empty closed queue = %v, %v; want false, true", ok, done)
+	}
+}
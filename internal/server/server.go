@@ -2,91 +2,235 @@ package server
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
 	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/coder/websocket"
-)
-
-// playerCounter is a simple incrementing counter used to assign player IDs.
-var playerCounter int
 
-// nextPlayerID returns a unique player ID.
-func nextPlayerID() string {
-	playerCounter++
-	return fmt.Sprintf("player-%d", playerCounter)
-}
+	"ebiten-fullstack-template/internal/protocol"
+)
 
 // Server holds the HTTP server components.
 type Server struct {
-	Hub  *Hub
-	Addr string
-	http *http.Server
+	Rooms  *RoomManager
+	config Config
+	http   *http.Server
+
+	// IDs allocates player IDs; replace it before Run for predictable IDs.
+	IDs IDAllocator
+
+	// Metrics counts server events.
+	Metrics *Metrics
+
+	// connLimit limits new connections per remote IP; created by Run.
+	connLimit *ipLimiter
+
+	// certs serves the TLS certificate and redirect is the HTTP to HTTPS
+	// listener; both are nil without TLS. certs is set by Run and read by
+	// ReloadCertificates from other goroutines.
+	certs    atomic.Pointer[certReloader]
+	redirect *http.Server
+
+	// done is closed on Shutdown to stop background goroutines.
+	done chan struct{}
+
+	// started is when the server was created; draining is set once
+	// Shutdown begins so that /readyz turns away new traffic, and stopping
+	// once the drain period is over and the rooms are closing.
+	started  time.Time
+	draining atomic.Bool
+	stopping atomic.Bool
 }
 
-// New creates a new Server on the given address.
-func New(addr string) *Server {
+// New creates a new Server with the given configuration; see LoadConfig.
+func New(cfg Config) *Server {
+	metrics := NewMetrics()
 	return &Server{
-		Hub:  NewHub(),
-		Addr: addr,
+		Rooms:   NewRoomManager(cfg, metrics),
+		config:  cfg,
+		IDs:     &RandomIDs{},
+		Metrics: metrics,
+		done:    make(chan struct{}),
+		started: time.Now(),
 	}
 }
 
-// handleWebSocket upgrades the HTTP connection to a WebSocket and registers
-// the new client with the hub.
+// handleWebSocket upgrades the HTTP connection to a WebSocket. The client
+// starts in the lobby, or enters the room named by the room query parameter
+// directly. The wire codec is picked with a negotiated subprotocol (see
+// protocol.Subprotocol) or else the codec query parameter.
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		InsecureSkipVerify: true, // allow all origins for development
-	})
-	if err != nil {
-		log.Printf("websocket upgrade error: %v", err)
+	if s.stopping.Load() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
+	roomCode := r.URL.Query().Get("room")
+	if roomCode != "" && !ValidRoomCode(roomCode) {
+		http.Error(w, "invalid room code", http.StatusBadRequest)
+		return
+	}
+
+	codec := protocol.JSONCodec
+	if name := r.URL.Query().Get("codec"); name != "" {
+		var ok bool
+		if codec, ok = protocol.CodecByName(name); !ok {
+			http.Error(w, "unsupported codec", http.StatusBadRequest)
+			return
+		}
+	}
+
 	remoteAddr := r.RemoteAddr
 	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
 		remoteAddr = host
 	}
-	log.Printf("websocket connected from %s", remoteAddr)
+	if s.connLimit != nil && !s.connLimit.allow(remoteAddr, time.Now()) {
+		s.Metrics.ConnectionsRejected.Add(1)
+		slog.Warn("connection refused: connection rate limit exceeded", "remote", remoteAddr)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
 
-	id := nextPlayerID()
-	client := NewClient(s.Hub, conn, id)
-	s.Hub.register <- client
+	// Same-origin pages are always accepted; other origins must be listed
+	// so that third-party sites cannot ride on a visitor's connection.
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols:       protocol.Subprotocols(),
+		InsecureSkipVerify: s.config.Dev,
+		OriginPatterns:     s.config.OriginPatterns,
+	})
+	if err != nil {
+		slog.Info("websocket upgrade failed", "remote", remoteAddr, "err", err)
+		return
+	}
+	if sub := conn.Subprotocol(); sub != "" {
+		codec, _ = protocol.CodecBySubprotocol(sub)
+	}
+	slog.Debug("websocket connected", "remote", remoteAddr, "codec", codec.Name())
+
+	hello, err := handshake(conn, codec, remoteAddr)
+	if err != nil {
+		slog.Info("handshake failed", "remote", remoteAddr, "err", err)
+		return
+	}
+
+	id := s.IDs.NextID()
+	client := NewClient(s.Rooms, conn, id, codec)
+	client.remote = remoteAddr
+	client.delta = hello.Has(protocol.FeatureDelta)
+	client.limitMessages(s.config.Limits.MessageRate, s.config.Limits.MessageBurst)
+	var hub *Hub
+	if roomCode != "" {
+		hub = s.Rooms.Acquire(roomCode)
+		if hub.banned(remoteAddr, time.Now()) {
+			s.Rooms.Release(hub)
+			slog.Info("connection refused: kicked from the room", "remote", remoteAddr, "room", hub.Code)
+			_ = conn.Close(websocket.StatusCode(protocol.CloseKicked), "kicked from room "+hub.Code)
+			return
+		}
+		if resumedID, ok := hub.State.Resume(hello.Token); ok {
+			client.ID = resumedID
+			client.resumed = true
+		}
+	}
+	client.setLogger(slog.With("player", client.ID, "remote", remoteAddr))
+	if hub != nil {
+		client.enterRoom(hub)
+	}
 
 	// Start the read and write pumps in separate goroutines.
+	s.Metrics.ClientsConnected.Add(1)
 	go client.WritePump()
 	go client.ReadPump()
 }
 
-// Run starts the hub and HTTP server.
+// handleRooms lists the running rooms as JSON.
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Rooms.List()); err != nil {
+		slog.Warn("write rooms failed", "err", err)
+	}
+}
+
+// Run starts the room manager and HTTP server, or HTTPS server if a
+// certificate is configured.
 func (s *Server) Run() error {
-	go s.Hub.Run()
+	s.connLimit = newIPLimiter(s.config.Limits.ConnectionRate, s.config.Limits.ConnectionBurst)
+	go s.Rooms.Run()
 
 	mux := http.NewServeMux()
 
-	// Serve static files from the web/ directory.
-	fs := http.FileServer(http.Dir("web"))
-	mux.Handle("/", fs)
+	// Static files of the web client.
+	mux.Handle("/", newAssetHandler(s.config.AssetsDir))
 
 	// WebSocket endpoint.
 	mux.HandleFunc("/ws", s.handleWebSocket)
 
+	// Room listing.
+	mux.HandleFunc("GET /rooms", s.handleRooms)
+
+	// Probes for load balancers and orchestrators.
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /info", s.handleInfo)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+
 	s.http = &http.Server{
-		Addr:    s.Addr,
+		Addr:    s.config.Addr,
 		Handler: mux,
 	}
-	log.Printf("server listening on http://localhost%s", s.Addr)
-	err := s.http.ListenAndServe()
+	var err error
+	if s.config.TLSCert != "" {
+		var certs *certReloader
+		if certs, err = newCertReloader(s.config.TLSCert, s.config.TLSKey); err != nil {
+			return err
+		}
+		s.certs.Store(certs)
+		go certs.watch(s.done)
+		s.http.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+		if s.config.RedirectAddr != "" {
+			s.redirect = &http.Server{
+				Addr:    s.config.RedirectAddr,
+				Handler: redirectHandler(s.config.Addr),
+			}
+			go s.listenRedirect()
+		}
+		slog.Info("server listening", "url", "https://localhost"+s.config.Addr)
+		err = s.http.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("server listening", "url", "http://localhost"+s.config.Addr)
+		err = s.http.ListenAndServe()
+	}
 	if err == http.ErrServerClosed {
 		return nil
 	}
 	return err
 }
 
-// Shutdown gracefully shuts down the HTTP server and the hub.
+// Shutdown gracefully shuts down the HTTP server and all room hubs. It first
+// fails /readyz and keeps serving for the configured drain period, or until
+// ctx is done, so that load balancers can move new players elsewhere.
 func (s *Server) Shutdown(ctx context.Context) error {
-	s.Hub.Stop()
+	s.draining.Store(true)
+	if s.config.DrainPeriod > 0 {
+		slog.Info("draining", "period", s.config.DrainPeriod)
+		select {
+		case <-time.After(s.config.DrainPeriod):
+		case <-ctx.Done():
+		}
+	}
+	s.stopping.Store(true)
+	s.Rooms.Stop()
+	close(s.done)
+	if s.redirect != nil {
+		_ = s.redirect.Shutdown(ctx)
+	}
 	if s.http != nil {
 		return s.http.Shutdown(ctx)
 	}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// newTestServer starts the WebSocket endpoint of a server with cfg and
+// returns the server and its ws:// URL.
+func newTestServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	s := New(cfg)
+	s.IDs = &SequentialIDs{}
+	go s.Rooms.Run()
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(func() {
+		ts.Close()
+		if !s.stopping.Load() {
+			s.Rooms.Stop()
+		}
+	})
+	return s, "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+// dial connects to room, or the lobby if room is "", with the JSON codec
+// and says hello with token.
+func dial(t *testing.T, url, room, token string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	target := url + "?codec=json"
+	if room != "" {
+		target += "&room=" + room
+	}
+	conn, _, err := websocket.Dial(ctx, target, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.CloseNow() })
+	writeMessage(t, conn, protocol.MsgHello, protocol.HelloData{
+		Version: protocol.Version, Build: protocol.BuildHash, Features: protocol.Features, Token: token,
+	})
+	return conn
+}
+
+// dialRoom connects to room like dial and returns the connection and the
+// welcome.
+func dialRoom(t *testing.T, url, room, token string) (*websocket.Conn, protocol.WelcomeData) {
+	t.Helper()
+	conn := dial(t, url, room, token)
+	var welcome protocol.WelcomeData
+	readMessage(t, conn, protocol.MsgWelcome, &welcome)
+	return conn, welcome
+}
+
+// writeMessage sends one JSON message on conn.
+func writeMessage(t *testing.T, conn *websocket.Conn, msgType protocol.MessageType, data interface{}) {
+	t.Helper()
+	msg, err := protocol.JSONCodec.Marshal(msgType, data)
+	if err != nil {
+		t.Fatalf("marshal %s: %v", msgType, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.Write(ctx, websocket.MessageText, msg); err != nil {
+		t.Fatalf("write %s: %v", msgType, err)
+	}
+}
+
+// readMessage reads from conn until a message of msgType arrives and
+// decodes it into v.
+func readMessage(t *testing.T, conn *websocket.Conn, msgType protocol.MessageType, v interface{}) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		env, err := protocol.JSONCodec.Unmarshal(msg)
+		if err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if env.Type != msgType {
+			continue
+		}
+		if err := env.Decode(v); err != nil {
+			t.Fatalf("decode %s: %v", msgType, err)
+		}
+		return
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	s, url := newTestServer(t, DefaultConfig())
+
+	first, welcome := dialRoom(t, url, "resume", "")
+	if welcome.Token == "" {
+		t.Fatal("welcome carries no session token")
+	}
+	_ = first.Close(websocket.StatusNormalClosure, "")
+
+	_, again := dialRoom(t, url, "resume", welcome.Token)
+	if again.ID != welcome.ID {
+		t.Errorf("resumed as %s, want %s", again.ID, welcome.ID)
+	}
+	if again.Color != welcome.Color {
+		t.Errorf("resumed with color %v, want %v", again.Color, welcome.Color)
+	}
+	if n := s.Rooms.Stats().Players; n != 1 {
+		t.Errorf("room holds %d players, want 1", n)
+	}
+}
+
+func TestResumeUnknownToken(t *testing.T) {
+	s, url := newTestServer(t, DefaultConfig())
+
+	_, first := dialRoom(t, url, "resume", "")
+	_, second := dialRoom(t, url, "resume", "not-a-token")
+	if second.ID == first.ID {
+		t.Errorf("unknown token took over player %s", first.ID)
+	}
+	if n := s.Rooms.Stats().Players; n != 2 {
+		t.Errorf("room holds %d players, want 2", n)
+	}
+}
+
+func TestKickedPlayerStaysOut(t *testing.T) {
+	_, url := newTestServer(t, DefaultConfig())
+	owner, _ := dialRoom(t, url, "kick", "")
+	kicked, victim := dialRoom(t, url, "kick", "")
+
+	writeMessage(t, owner, protocol.MsgCommand, protocol.CommandData{Line: "kick " + victim.ID})
+	var res protocol.CommandResultData
+	readMessage(t, owner, protocol.MsgCommandResult, &res)
+	if !res.OK {
+		t.Fatalf("kick failed: %s", res.Text)
+	}
+	kickedCode := websocket.StatusCode(protocol.CloseKicked)
+	if ce := readClose(t, kicked); ce.Code != kickedCode {
+		t.Fatalf("kicked client closed with %d %q, want %d", ce.Code, ce.Reason, kickedCode)
+	}
+
+	// Redialling the room at once, as a client with the room in its URL
+	// would, is turned away.
+	if ce := readClose(t, dial(t, url, "kick", victim.Token)); ce.Code != kickedCode {
+		t.Errorf("redial closed with %d %q, want %d", ce.Code, ce.Reason, kickedCode)
+	}
+
+	// So is joining from the lobby, whatever the case of the code.
+	lobby := dial(t, url, "", "")
+	writeMessage(t, lobby, protocol.MsgJoinRoom, protocol.JoinRoomData{Code: "KICK"})
+	var join protocol.JoinResultData
+	readMessage(t, lobby, protocol.MsgJoinResult, &join)
+	if join.OK || !strings.Contains(join.Error, "kicked") {
+		t.Errorf("lobby join = %+v, want a refusal", join)
+	}
+
+	// Other rooms stay open.
+	dialRoom(t, url, "elsewhere", "")
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// DefaultSessionGrace is how long a disconnected player is kept so that the
+// client can resume the session with its token.
+const DefaultSessionGrace = 30 * time.Second
+
+// newSessionToken returns a random, unguessable session token.
+func newSessionToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Disconnect keeps a player whose connection dropped in the game until
+// expires, so the session can be resumed.
+func (gs *GameState) Disconnect(id string, expires time.Time) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if p, ok := gs.Players[id]; ok {
+		p.Expires = expires
+	}
+}
+
+// Resume looks up the player holding token and marks it connected again, so
+// that it cannot expire before the hub hands it to the new connection.
+func (gs *GameState) Resume(token string) (id string, ok bool) {
+	if token == "" {
+		return "", false
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for id, p := range gs.Players {
+		if p.Token == token {
+			p.Expires = time.Time{}
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// Reconnect marks a player connected and returns its state. The player's
+// input sequence restarts because the new connection numbers its inputs from
+// one, so the hub must call it only once the old connection is detached and
+// its queued inputs are gone.
+func (gs *GameState) Reconnect(id string) (PlayerState, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	p, ok := gs.Players[id]
+	if !ok {
+		return PlayerState{}, false
+	}
+	p.Expires = time.Time{}
+	p.LastSeq = 0
+	return *p, true
+}
+
+// Expire removes disconnected players whose grace period ended before now
+// and returns their IDs.
+func (gs *GameState) Expire(now time.Time) []string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	var expired []string
+	for id, p := range gs.Players {
+		if !p.Expires.IsZero() && now.After(p.Expires) {
+			delete(gs.Players, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// Len returns the number of players, including disconnected ones.
+func (gs *GameState) Len() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return len(gs.Players)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/coder/websocket"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// SlowClientPolicy decides what happens to state snapshots for a client that
+// does not read them as fast as the hub sends them.
+type SlowClientPolicy string
+
+const (
+	// Coalesce keeps at most one unsent state snapshot per client: each new
+	// snapshot replaces the queued one and goes to the back of the queue, so
+	// a slow client skips straight to the latest state.
+	Coalesce SlowClientPolicy = "coalesce"
+
+	// DropOldest queues snapshots like any other message and, when the
+	// queue is full, discards the oldest queued snapshot to make room. The
+	// client is disconnected if no snapshot is queued.
+	DropOldest SlowClientPolicy = "drop-oldest"
+
+	// Disconnect drops a client as soon as its send queue is full.
+	Disconnect SlowClientPolicy = "disconnect"
+)
+
+// valid reports whether p is one of the policies above.
+func (p SlowClientPolicy) valid() bool {
+	switch p {
+	case Coalesce, DropOldest, Disconnect:
+		return true
+	}
+	return false
+}
+
+// parseSlowClientPolicy parses a policy name.
+func parseSlowClientPolicy(v string, dst *SlowClientPolicy) error {
+	if p := SlowClientPolicy(v); p.valid() {
+		*dst = p
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q: want %s, %s or %s", v, Coalesce, DropOldest, Disconnect)
+}
+
+// sendState queues a state snapshot for client according to the hub's slow
+// client policy. Every snapshot is a keyframe or a delta against a tick the
+// client acknowledged, so skipping some never corrupts the client's state.
+// MUST be called only from the Hub.Run goroutine.
+func (h *Hub) sendState(client *Client, msg []byte) {
+	if h.slowPolicy != Coalesce {
+		h.sendTo(client, protocol.MsgState, msg)
+		return
+	}
+	removed, ok := client.send.replace(outgoing{protocol.MsgState, msg})
+	h.metrics.SnapshotsDropped.Add(uint64(removed))
+	if !ok {
+		h.evict(client)
+		return
+	}
+	h.metrics.messageOut(protocol.MsgState, len(msg))
+}
+
+// evict disconnects a client that cannot keep up, removing its player. The
+// leave is announced by announceEvictions, as evict may be called while the
+// hub is iterating over its clients. MUST be called only from the Hub.Run
+// goroutine.
+func (h *Hub) evict(client *Client) {
+	h.metrics.SlowClientDrops.Add(1)
+	client.logger().Warn("disconnecting slow client",
+		"policy", h.slowPolicy, "queued", client.send.len())
+	// Set the close status before dropClient closes send, so that the
+	// WritePump closes with it too.
+	client.closeWith(websocket.StatusCode(protocol.CloseSlowConsumer),
+		"connection too slow to keep up with the game")
+	h.dropClient(client)
+	h.evicted = append(h.evicted, client.ID)
+}
+
+// announceEvictions broadcasts a leave for every evicted player. Broadcasting
+// can evict more clients, which are announced in turn. MUST be called only
+// from the Hub.Run goroutine.
+func (h *Hub) announceEvictions() {
+	for len(h.evicted) > 0 {
+		id := h.evicted[0]
+		h.evicted = h.evicted[1:]
+		h.broadcastMessage(protocol.MsgLeave, protocol.LeaveData{ID: id})
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+// readClose reads from conn until the peer closes it and returns the close
+// error.
+func readClose(t *testing.T, conn *websocket.Conn) websocket.CloseError {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, _, err := conn.Read(ctx)
+		if err == nil {
+			continue
+		}
+		var ce websocket.CloseError
+		if !errors.As(err, &ce) {
+			t.Fatalf("read: %v, want a close", err)
+		}
+		return ce
+	}
+}
+
+func TestDroppedClientCloseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		drop   func(h *Hub, c *Client)
+		code   websocket.StatusCode
+		reason string
+	}{
+		{
+			name:   "evicted",
+			drop:   func(h *Hub, c *Client) { h.evict(c) },
+			code:   websocket.StatusCode(protocol.CloseSlowConsumer),
+			reason: "too slow",
+		},
+		{
+			name:   "kicked",
+			drop:   func(h *Hub, c *Client) { h.kick(c.ID) },
+			code:   websocket.StatusCode(protocol.CloseKicked),
+			reason: "kicked",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, rooms := newTestHub(DefaultConfig())
+			c, conn := addTestClient(t, h, rooms, "p1")
+			// The WritePump sees send closed at once and races the
+			// hub's close; either way the status must be the same.
+			go c.WritePump()
+
+			tt.drop(h, c)
+			ce := readClose(t, conn)
+			if ce.Code != tt.code || !strings.Contains(ce.Reason, tt.reason) {
+				t.Errorf("closed with %d %q, want %d and a reason containing %q",
+					ce.Code, ce.Reason, tt.code, tt.reason)
+			}
+			if _, ok := h.State.Player(c.ID); ok {
+				t.Error("player still in the game")
+			}
+		})
+	}
+}
+
+// queuedTypes returns the types of the messages in q, oldest first.
+func queuedTypes(q *sendQueue) []protocol.MessageType {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var types []protocol.MessageType
+	for _, m := range q.msgs {
+		types = append(types, m.msgType)
+	}
+	return types
+}
+
+func TestSlowClientPolicy(t *testing.T) {
+	const (
+		join  = protocol.MsgJoin
+		leave = protocol.MsgLeave
+		state = protocol.MsgState
+	)
+	tests := []struct {
+		name    string
+		policy  SlowClientPolicy
+		queued  []protocol.MessageType
+		send    protocol.MessageType
+		want    []protocol.MessageType
+		evicted bool
+	}{
+		{
+			name:   "drop-oldest skips a leading event",
+			policy: DropOldest,
+			queued: []protocol.MessageType{join, state, state},
+			send:   leave,
+			want:   []protocol.MessageType{join, state, leave},
+		},
+		{
+			name:    "drop-oldest without snapshots",
+			policy:  DropOldest,
+			queued:  []protocol.MessageType{join, leave, join},
+			send:    state,
+			evicted: true,
+		},
+		{
+			name:   "coalesce queues the snapshot after events",
+			policy: Coalesce,
+			queued: []protocol.MessageType{state, leave},
+			send:   state,
+			want:   []protocol.MessageType{leave, state},
+		},
+		{
+			name:   "coalesce fits into a full queue holding a snapshot",
+			policy: Coalesce,
+			queued: []protocol.MessageType{join, state, leave},
+			send:   state,
+			want:   []protocol.MessageType{join, leave, state},
+		},
+		{
+			name:    "coalesce without room",
+			policy:  Coalesce,
+			queued:  []protocol.MessageType{join, leave, join},
+			send:    state,
+			evicted: true,
+		},
+		{
+			name:    "disconnect",
+			policy:  Disconnect,
+			queued:  []protocol.MessageType{state, state, state},
+			send:    state,
+			evicted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.SendBufferSize = len(tt.queued)
+			cfg.SlowClientPolicy = tt.policy
+			h, rooms := newTestHub(cfg)
+			c, _ := addTestClient(t, h, rooms, "p1")
+			for _, msgType := range tt.queued {
+				c.send.push(outgoing{msgType: msgType})
+			}
+
+			if tt.send == state {
+				h.sendState(c, nil)
+			} else {
+				h.sendTo(c, tt.send, nil)
+			}
+
+			if evicted := !h.clients[c]; evicted != tt.evicted {
+				t.Fatalf("evicted = %v, want %v", evicted, tt.evicted)
+			}
+			if tt.evicted {
+				if len(h.evicted) != 1 || h.evicted[0] != c.ID {
+					t.Errorf("leave pending for %v, want [%s]", h.evicted, c.ID)
+				}
+				return
+			}
+			if got := queuedTypes(c.send); !slices.Equal(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
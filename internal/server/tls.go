@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certPollInterval is how often the certificate files are checked for
+// changes, e.g. after a renewal.
+const certPollInterval = 30 * time.Second
+
+// certReloader serves a TLS certificate loaded from disk and reloads it
+// when the files change or Reload is called. It is safe for concurrent use.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the certificate and key.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate and key again. The old certificate stays in
+// use if they cannot be loaded.
+func (r *certReloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// latestModTime returns the newer modification time of the two files.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// watch reloads the certificate whenever the files change until stop is
+// closed.
+func (r *certReloader) watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(certPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTime, err := r.latestModTime()
+			r.mu.RLock()
+			changed := err == nil && !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				slog.Error("reload TLS certificate failed", "err", err)
+			} else {
+				slog.Info("reloaded TLS certificate", "file", r.certFile)
+			}
+		}
+	}
+}
+
+// ReloadCertificates reloads the TLS certificate and key from disk, e.g. on
+// SIGHUP. It is a no-op without TLS.
+func (s *Server) ReloadCertificates() error {
+	certs := s.certs.Load()
+	if certs == nil {
+		return nil
+	}
+	return certs.Reload()
+}
+
+// redirectHandler redirects plain HTTP requests to the same URL on the
+// HTTPS listener at tlsAddr.
+func redirectHandler(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// listenRedirect serves the HTTP to HTTPS redirect until shut down.
+func (s *Server) listenRedirect() {
+	slog.Info("redirecting HTTP to HTTPS", "addr", s.redirect.Addr)
+	if err := s.redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("redirect listener failed", "err", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Package sim holds the movement rules shared by the client and the server,
+// so that both simulate the same input to the same position.
+package sim
+
+import (
+	"math"
+
+	"ebiten-fullstack-template/internal/protocol"
+)
+
+const (
+	WorldWidth   = 640
+	WorldHeight  = 480
+	PlayerRadius = 8
+
+	// PlayerSpeed is the distance a player moves per input frame.
+	PlayerSpeed = 3
+
+	// FrameRate is the number of input frames a client produces per second.
+	FrameRate = 60
+)
+
+// MoveToward returns (nx, ny) one step of speed toward (tx, ty) from (cx, cy).
+func MoveToward(cx, cy, tx, ty, speed float64) (float64, float64) {
+	dx, dy := tx-cx, ty-cy
+	dist := math.Sqrt(dx*dx + dy*dy)
+	if dist <= speed {
+		return tx, ty
+	}
+	return cx + dx/dist*speed, cy + dy/dist*speed
+}
+
+// Step applies one frame of input to the position (x, y) and returns the
+// new position clamped to the world bounds.
+func Step(x, y float64, in protocol.InputData) (float64, float64) {
+	if in.Up {
+		y -= PlayerSpeed
+	}
+	if in.Down {
+		y += PlayerSpeed
+	}
+	if in.Left {
+		x -= PlayerSpeed
+	}
+	if in.Right {
+		x += PlayerSpeed
+	}
+
+	// Pointer: move toward the mouse cursor or touch position.
+	if in.Pointer {
+		x, y = MoveToward(x, y, in.TX, in.TY, PlayerSpeed)
+	}
+
+	return Clamp(x, y)
+}
+
+// Clamp keeps (x, y) inside the world bounds.
+func Clamp(x, y float64) (float64, float64) {
+	x = math.Max(PlayerRadius, math.Min(x, WorldWidth-PlayerRadius))
+	y = math.Max(PlayerRadius, math.Min(y, WorldHeight-PlayerRadius))
+	return x, y
+}
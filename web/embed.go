@@ -0,0 +1,11 @@
+// Package web holds the browser client's static files.
+package web
+
+import "embed"
+
+// Files are the static files embedded into the server binary. Build the
+// client (make build-wasm) before the server so that client.wasm and its
+// precompressed variants are included.
+//
+//go:embed *
+var Files embed.FS